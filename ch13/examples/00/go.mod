@@ -1,5 +1,5 @@
 module github.com/chai2010/go-ast-book/ssa/01
 
-go 1.14
+go 1.25.0
 
-require golang.org/x/tools v0.0.0-20200601175630-2caf76543d99
+require golang.org/x/tools v0.47.0
@@ -37,6 +37,7 @@ func main() {
 		Implicits:  make(map[ast.Node]types.Object),
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
 	}
 
 	conf := types.Config{Importer: nil}
@@ -45,7 +46,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions)
+	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
 	var ssaPkg = ssaProg.CreatePackage(pkg, []*ast.File{f}, info, true)
 
 	ssaPkg.Build()
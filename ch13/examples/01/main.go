@@ -40,7 +40,7 @@ func main() {
 	prog.LoadPackage("main")
 	prog.LoadPackage("runtime")
 
-	var ssaProg = ssa.NewProgram(prog.fset, ssa.SanityCheckFunctions)
+	var ssaProg = ssa.NewProgram(prog.fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
 	var ssaMainPkg *ssa.Package
 
 	for name, pkg := range prog.pkgs {
@@ -52,7 +52,7 @@ func main() {
 	ssaProg.Build()
 
 	exitCode := interp.Interpret(
-		ssaMainPkg, 0, &types.StdSizes{8, 8},
+		ssaMainPkg, 0, &types.StdSizes{WordSize: 8, MaxAlign: 8},
 		"main", []string{},
 	)
 	if exitCode != 0 {
@@ -95,6 +95,7 @@ func (p *Program) LoadPackage(path string) (pkg *types.Package, f *ast.File, err
 		Implicits:  make(map[ast.Node]types.Object),
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
 	}
 
 	conf := types.Config{Importer: p}
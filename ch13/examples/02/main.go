@@ -25,7 +25,7 @@ func main() {
 
 	pkg, f, info, _ := prog.LoadPackage("main")
 
-	var ssaProg = ssa.NewProgram(prog.fset, ssa.SanityCheckFunctions)
+	var ssaProg = ssa.NewProgram(prog.fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
 	var ssaPkg = ssaProg.CreatePackage(pkg, []*ast.File{f}, info, true)
 	ssaPkg.Build()
 
@@ -69,6 +69,7 @@ func (p *Program) LoadPackage(path string) (pkg *types.Package, f *ast.File, inf
 		Implicits:  make(map[ast.Node]types.Object),
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
 	}
 
 	conf := types.Config{Importer: p}
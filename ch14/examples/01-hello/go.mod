@@ -1,8 +1,18 @@
 module ssago
 
-go 1.14
+go 1.25.0
 
 require (
 	github.com/llir/llvm v0.3.2
-	golang.org/x/tools v0.0.0-20200713011307-fd294ab11aed
+	golang.org/x/tools v0.47.0
+	llvmdriver v0.0.0
 )
+
+require (
+	github.com/mewmew/float v0.0.0-20191226120903-16bbe2fdd85e // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/mod v0.37.0 // indirect
+	golang.org/x/sync v0.21.0 // indirect
+)
+
+replace llvmdriver => ../llvmdriver
@@ -1,67 +1,976 @@
 package main
 
 import (
+	"fmt"
+	goconstant "go/constant"
+	"go/token"
 	"go/types"
+	"sort"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
 	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
 	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types/typeutil"
+
+	"llvmdriver/lldebug"
+	"ssago/lltypes"
 )
 
-func llModule(ssafnMain *ssa.Function) *ir.Module {
-	m := ir.NewModule()
-
-	// printf
-	i8Ptr := llvmTypes.NewPointer(llvmTypes.I8)
-	printf := m.NewFunc("printf", llvmTypes.I32, ir.NewParam("format", i8Ptr))
-	printf.Sig.Variadic = true
-
-	// global printf format string
-	printf_format_int := m.NewGlobalDef("printf_format_int", constant.NewCharArray([]byte("%d\n\x00")))
-	printf_format_int.Immutable = true
-
-	// main
-	fnMain := m.NewFunc("main", llvmTypes.I32)
-	firstBlock := fnMain.NewBlock("entry")
-
-	// main body
-	for _, ins := range ssafnMain.Blocks[0].Instrs {
-		switch ins := ins.(type) {
-		case *ssa.Call:
-			if ins.Call.Method == nil {
-				if fnBuiltin, ok := ins.Call.Value.(*ssa.Builtin); ok {
-					if fnBuiltin.Name() == "println" {
-						llPrint(firstBlock, printf, printf_format_int, ins.Call.Args...)
-					}
+// LLVM模块的生成器
+type llBuilder struct {
+	m     *ir.Module
+	types *lltypes.Mapper
+	prog  *ssa.Program
+	fset  *token.FileSet
+
+	// SSA函数对应的LLVM函数
+	funcs map[*ssa.Function]*ir.Func
+
+	// 已经声明但是还没有生成函数体的函数
+	queue []*ssa.Function
+
+	// 函数作为值使用时的静态闭包
+	funcValues map[*ssa.Function]*ir.Global
+
+	// SSA全局变量对应的LLVM全局变量
+	globals map[*ssa.Global]*ir.Global
+
+	// 字符串常量
+	strs map[string]*ir.Global
+
+	// 已经声明的运行时函数
+	runtime map[string]*ir.Func
+
+	// 接口使用的类型描述符/接口描述符/方法表, 以及方法的接口调用入口
+	typeDescs typeutil.Map
+	itypes    typeutil.Map
+	itabs     map[[2]*ir.Global]*ir.Global
+	thunks    map[*ssa.Function]*ir.Func
+	typeDescT *llvmTypes.StructType
+
+	// 可比较类型的比较函数和哈希函数
+	equalFuncs typeutil.Map
+	hashFuncs  typeutil.Map
+
+	// 调试信息
+	debug *llDebug
+}
+
+// 函数级别的状态
+type llFrame struct {
+	fn *ir.Func
+
+	// 入口块, 栈上的变量在入口块中分配
+	entry *ir.Block
+
+	// 函数的调试信息
+	sp *metadata.DISubprogram
+
+	// SSA块对应的LLVM块
+	blocks map[*ssa.BasicBlock]*ir.Block
+
+	// SSA块最后生成的LLVM块, 作为后继块中phi节点的前驱
+	exits map[*ssa.BasicBlock]*ir.Block
+
+	// SSA值对应的LLVM值
+	values map[ssa.Value]value.Value
+
+	// phi节点的入边在全部块生成之后再填充
+	phis map[*ssa.Phi]*ir.InstPhi
+
+	// 有defer语句的函数在运行时中注册的栈帧, 以及_setjmp返回时是否正在恢复
+	frame      value.Value
+	recovering value.Value
+
+	// 调用recover的函数是否被延迟调用直接调用(i32), 为nil时recover总是返回nil
+	canRecover value.Value
+
+	// 变量地址的副本, 从恢复点返回之后寄存器中的地址不可靠, 从副本中读取
+	slots map[*ssa.Alloc]*ir.InstAlloca
+
+	// 已经生成的延迟调用包装函数的个数
+	ndefers int
+}
+
+// 生成程序中全部包的代码, mainPkg为main函数所在的包
+func llModule(prog *ssa.Program, mainPkg *ssa.Package, target *lltypes.Target) *ir.Module {
+	b := &llBuilder{
+		m:          ir.NewModule(),
+		prog:       prog,
+		fset:       prog.Fset,
+		funcs:      make(map[*ssa.Function]*ir.Func),
+		globals:    make(map[*ssa.Global]*ir.Global),
+		strs:       make(map[string]*ir.Global),
+		runtime:    make(map[string]*ir.Func),
+		itabs:      make(map[[2]*ir.Global]*ir.Global),
+		thunks:     make(map[*ssa.Function]*ir.Func),
+		funcValues: make(map[*ssa.Function]*ir.Global),
+	}
+	b.types = lltypes.NewMapper(target, b.m)
+	b.debug = newLLDebug(b, mainPkg)
+
+	pkgs := llPackageOrder(prog, mainPkg)
+
+	// 先声明全部的全局变量和包级函数, 函数体中可以引用后面定义的函数
+	// 方法和包装函数在第一次被引用时加入队列
+	for _, pkg := range pkgs {
+		for _, g := range llPackageGlobals(pkg) {
+			b.globals[g] = b.llGlobal(g)
+		}
+		for _, ssafn := range llPackageFuncs(pkg) {
+			b.llFuncRef(ssafn)
+		}
+	}
+
+	// 没有函数体的函数是外部函数, 只有声明
+	// unsafe包没有源码, SSA中它的init函数也没有函数体, 生成一个空函数
+	for len(b.queue) > 0 {
+		ssafn := b.queue[0]
+		b.queue = b.queue[1:]
+		switch {
+		case len(ssafn.Blocks) > 0:
+			b.llFunc(ssafn, b.funcs[ssafn])
+		case ssafn.Pkg != nil && ssafn.Pkg.Pkg == types.Unsafe && ssafn.Name() == "init":
+			b.funcs[ssafn].NewBlock("entry").NewRet(nil)
+		}
+	}
+
+	// C语言的main函数按依赖顺序执行包的初始化, 然后调用Go的main函数
+	// 包的init函数中有是否已经初始化的标志, 重复调用没有影响
+	fnMain := b.m.NewFunc("main", llvmTypes.I32)
+	entry := fnMain.NewBlock("entry")
+	for _, pkg := range pkgs {
+		if fnInit := pkg.Func("init"); fnInit != nil {
+			entry.NewCall(b.llFuncRef(fnInit))
+		}
+	}
+	entry.NewCall(b.llFuncRef(mainPkg.Func("main")))
+	entry.NewRet(constant.NewInt(llvmTypes.I32, 0))
+
+	return b.m
+}
+
+// 按依赖顺序排列的包, 被导入的包在前面
+func llPackageOrder(prog *ssa.Program, mainPkg *ssa.Package) []*ssa.Package {
+	var pkgs []*ssa.Package
+	seen := make(map[*types.Package]bool)
+
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		for _, dep := range pkg.Imports() {
+			visit(dep)
+		}
+		if p := prog.Package(pkg); p != nil {
+			pkgs = append(pkgs, p)
+		}
+	}
+	visit(mainPkg.Pkg)
+	return pkgs
+}
+
+// 包中的全局变量, 按名字排序保证输出稳定
+func llPackageGlobals(pkg *ssa.Package) []*ssa.Global {
+	var globals []*ssa.Global
+	for _, m := range pkg.Members {
+		if g, ok := m.(*ssa.Global); ok {
+			globals = append(globals, g)
+		}
+	}
+	sort.Slice(globals, func(i, j int) bool {
+		return globals[i].Name() < globals[j].Name()
+	})
+	return globals
+}
+
+// 包中的函数, 按名字排序保证输出稳定
+// 源码中的init函数不是包的成员, 从合成的包初始化函数的调用中查找
+// 泛型函数只生成实例化之后的版本
+func llPackageFuncs(pkg *ssa.Package) []*ssa.Function {
+	var funcs []*ssa.Function
+	for _, m := range pkg.Members {
+		if fn, ok := m.(*ssa.Function); ok && fn.TypeParams().Len() == 0 {
+			funcs = append(funcs, fn)
+		}
+	}
+	if fnInit := pkg.Func("init"); fnInit != nil {
+		for _, blk := range fnInit.Blocks {
+			for _, ins := range blk.Instrs {
+				call, ok := ins.(*ssa.Call)
+				if !ok {
+					continue
+				}
+				if fn, ok := call.Call.Value.(*ssa.Function); ok && fn.Pkg == pkg && fn != fnInit && pkg.Members[fn.Name()] == nil {
+					funcs = append(funcs, fn)
 				}
 			}
-		default:
-			break
 		}
 	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Name() < funcs[j].Name()
+	})
+	return funcs
+}
 
-	firstBlock.NewRet(constant.NewInt(llvmTypes.I32, 0))
-	return m
+// 声明全局变量, 初始值为零值, 源码中的初始化表达式在包的init函数中执行
+func (b *llBuilder) llGlobal(g *ssa.Global) *ir.Global {
+	t := b.types.Type(llElem(g.Type()))
+	return b.m.NewGlobalDef(g.Pkg.Pkg.Name()+"."+g.Name(), llZero(t))
 }
 
-func llPrint(block *ir.Block, printf *ir.Func, format *ir.Global, args ...ssa.Value) {
-	for _, arg := range args {
-		if arg, ok := arg.(*ssa.Const); ok {
-			if t, ok := arg.Type().Underlying().(*types.Basic); ok {
-				switch t.Kind() {
-				case types.Int, types.UntypedInt:
-					block.NewCall(
-						printf,
-						block.NewGetElementPtr(
-							format.Type().(*llvmTypes.PointerType).ElemType, format,
-							constant.NewInt(llvmTypes.I32, 0),
-							constant.NewInt(llvmTypes.I32, 0),
-						),
-						constant.NewInt(llvmTypes.I32, arg.Int64()),
-					)
-				}
+// SSA函数对应的LLVM函数, 第一次引用时声明并加入生成函数体的队列
+func (b *llBuilder) llFuncRef(ssafn *ssa.Function) *ir.Func {
+	if fn, ok := b.funcs[ssafn]; ok {
+		return fn
+	}
+	fn := b.llDeclare(ssafn)
+	b.funcs[ssafn] = fn
+	b.queue = append(b.queue, ssafn)
+	return fn
+}
+
+// 声明函数, 参数和返回值类型来自函数签名, 方法的接收者是第一个参数
+// 有自由变量的函数增加闭包上下文作为第一个参数
+// 外部函数使用原始的名字, 以便和C语言实现的函数链接; 其它函数的名字带包名前缀
+func (b *llBuilder) llDeclare(ssafn *ssa.Function) *ir.Func {
+	sig := ssafn.Signature
+	var params []*ir.Param
+	if len(ssafn.FreeVars) > 0 {
+		params = append(params, ir.NewParam("ctx", llvmTypes.I8Ptr))
+	}
+
+	var vars []*types.Var
+	if recv := sig.Recv(); recv != nil {
+		vars = append(vars, recv)
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		vars = append(vars, sig.Params().At(i))
+	}
+
+	for _, v := range vars {
+		params = append(params, ir.NewParam(v.Name(), b.types.Type(v.Type())))
+	}
+	return b.m.NewFunc(llFuncName(ssafn), b.types.ResultType(sig.Results()), params...)
+}
+
+// 函数的链接名, 方法的名字形如main.(*T).M
+// 包装函数不属于任何包, 使用方法所在的包名
+func llFuncName(ssafn *ssa.Function) string {
+	if len(ssafn.Blocks) == 0 && ssafn.Synthetic == "" {
+		return ssafn.Name()
+	}
+	var pkg *types.Package
+	if ssafn.Pkg != nil {
+		pkg = ssafn.Pkg.Pkg
+	} else if obj := ssafn.Object(); obj != nil {
+		pkg = obj.Pkg()
+	}
+	if pkg == nil {
+		return ssafn.RelString(nil)
+	}
+	return pkg.Name() + "." + ssafn.RelString(pkg)
+}
+
+// 将SSA函数的全部块翻译到LLVM函数中
+func (b *llBuilder) llFunc(ssafn *ssa.Function, fn *ir.Func) {
+	fr := &llFrame{
+		fn:     fn,
+		blocks: make(map[*ssa.BasicBlock]*ir.Block),
+		exits:  make(map[*ssa.BasicBlock]*ir.Block),
+		values: make(map[ssa.Value]value.Value),
+		phis:   make(map[*ssa.Phi]*ir.InstPhi),
+		slots:  make(map[*ssa.Alloc]*ir.InstAlloca),
+	}
+
+	// 入口块只用于分配栈上的变量, 然后跳转到SSA的第一个块
+	fr.entry = fn.NewBlock("entry")
+	fr.sp = b.debug.subprogram(ssafn, fn)
+	loc := b.debug.location(fr.sp, ssafn.Pos())
+
+	// 闭包的第一个参数是上下文, 从中读取自由变量
+	params := fn.Params
+	if len(ssafn.FreeVars) > 0 {
+		b.llFreeVars(fr, ssafn, params[0])
+		params = params[1:]
+	}
+	for i, p := range ssafn.Params {
+		fr.values[p] = params[i]
+		if v, ok := p.Object().(*types.Var); ok {
+			b.debug.value(fr.entry, b.debug.variable(fr.sp, v, p.Type(), len(fn.Params)-len(params)+i+1), params[i])
+		}
+	}
+
+	if llCallsRecover(ssafn) {
+		b.llRecoverEnter(fr)
+	}
+	if ssafn.Recover != nil {
+		b.llDeferFrame(fr)
+	}
+
+	// 先创建全部的块, 跳转指令可以引用后面的块
+	for _, blk := range ssafn.Blocks {
+		fr.blocks[blk] = fn.NewBlock(fmt.Sprintf("%s.%d", blk.Comment, blk.Index))
+	}
+
+	// 按支配树的前序遍历生成指令, 保证值的定义先于使用
+	// 运行时检查会拆分基本块, 一个SSA块可能对应多个LLVM块
+	// 没有位置信息的指令沿用前一条指令的位置
+	for _, blk := range ssafn.DomPreorder() {
+		block := fr.blocks[blk]
+		var saved map[ssa.Value]value.Value
+		if blk == ssafn.Recover {
+			saved = b.llReloadSlots(fr, block)
+		}
+		for _, ins := range blk.Instrs {
+			if ins.Pos().IsValid() {
+				loc = b.debug.location(fr.sp, ins.Pos())
+			}
+			start, nblocks := block, len(fn.Blocks)
+			offset := len(block.Insts)
+
+			block = b.llInstr(fr, block, ins)
+
+			lldebug.SetLoc(start, offset, loc)
+			for _, newBlock := range fn.Blocks[nblocks:] {
+				lldebug.SetLoc(newBlock, 0, loc)
+			}
+		}
+		fr.exits[blk] = block
+		for v, x := range saved {
+			fr.values[v] = x
+		}
+	}
+
+	if fr.frame != nil {
+		fr.entry.NewCondBr(fr.recovering, fr.blocks[ssafn.Recover], fr.blocks[ssafn.Blocks[0]])
+	} else {
+		fr.entry.NewBr(fr.blocks[ssafn.Blocks[0]])
+	}
+	lldebug.SetLoc(fr.entry, 0, b.debug.location(fr.sp, ssafn.Pos()))
+
+	// 填充phi节点的入边
+	for phi, llPhi := range fr.phis {
+		for i, pred := range phi.Block().Preds {
+			llPhi.Incs = append(llPhi.Incs, ir.NewIncoming(b.llValue(fr, phi.Edges[i]), fr.exits[pred]))
+		}
+	}
+}
+
+// 生成一条指令, 返回后续指令所在的块
+func (b *llBuilder) llInstr(fr *llFrame, block *ir.Block, ins ssa.Instruction) *ir.Block {
+	switch ins := ins.(type) {
+	case *ssa.DebugRef:
+		b.debug.debugRef(fr, block, ins)
+
+	case *ssa.Phi:
+		phi := &ir.InstPhi{Typ: b.types.Type(ins.Type())}
+		block.Insts = append(block.Insts, phi)
+		fr.phis[ins] = phi
+		fr.values[ins] = phi
+
+	case *ssa.BinOp:
+		fr.values[ins], block = b.llBinOp(fr, block, ins)
+
+	case *ssa.UnOp:
+		if ins.Op == token.MUL {
+			fr.values[ins], block = b.llLoad(fr, block, ins.X, ins.Pos())
+			break
+		}
+		fr.values[ins] = b.llUnOp(fr, block, ins)
+
+	case *ssa.Convert:
+		fr.values[ins] = b.llConvert(block, b.llValue(fr, ins.X), ins.X.Type(), ins.Type())
+
+	case *ssa.ChangeType:
+		fr.values[ins] = b.llCast(fr, block, b.llValue(fr, ins.X), b.types.Type(ins.Type()))
+
+	case *ssa.Alloc:
+		fr.values[ins] = b.llAlloc(fr, block, ins)
+
+	case *ssa.Store:
+		block = b.llStore(fr, block, ins)
+
+	case *ssa.FieldAddr:
+		fr.values[ins], block = b.llFieldAddr(fr, block, ins)
+
+	case *ssa.Field:
+		fr.values[ins] = block.NewExtractValue(b.llValue(fr, ins.X), uint64(ins.Field))
+
+	case *ssa.IndexAddr:
+		fr.values[ins], block = b.llIndexAddr(fr, block, ins)
+
+	case *ssa.Index:
+		fr.values[ins], block = b.llIndex(fr, block, ins.X, ins.Index, ins.Pos())
+
+	case *ssa.Slice:
+		fr.values[ins], block = b.llSlice(fr, block, ins)
+
+	case *ssa.MakeSlice:
+		fr.values[ins] = b.llMakeSlice(fr, block, ins)
+
+	case *ssa.MakeMap:
+		fr.values[ins] = b.llMakeMap(block, ins)
+
+	case *ssa.MapUpdate:
+		b.llMapUpdate(fr, block, ins)
+
+	case *ssa.Lookup:
+		fr.values[ins] = b.llMapLookup(fr, block, ins)
+
+	case *ssa.Range:
+		fr.values[ins] = b.llRange(fr, block, ins)
+
+	case *ssa.Next:
+		fr.values[ins] = b.llNext(fr, block, ins)
+
+	case *ssa.Call:
+		fr.values[ins], block = b.llCallCommon(fr, block, ins.Common(), ins.Type(), ins.Pos())
+
+	case *ssa.Defer:
+		b.llDefer(fr, block, ins)
+
+	case *ssa.RunDefers:
+		b.llRuntime(block, "wa_rundefers", llvmTypes.Void, fr.frame)
+
+	case *ssa.Panic:
+		b.llPanic(fr, block, ins.X, ins.Pos())
+		block.NewUnreachable()
+
+	case *ssa.MakeClosure:
+		fr.values[ins] = b.llMakeClosure(fr, block, ins)
+
+	case *ssa.MakeInterface:
+		fr.values[ins] = b.llMakeInterface(fr, block, ins)
+
+	case *ssa.ChangeInterface:
+		fr.values[ins] = b.llChangeInterface(fr, block, ins)
+
+	case *ssa.TypeAssert:
+		fr.values[ins], block = b.llTypeAssert(fr, block, ins)
+
+	case *ssa.Extract:
+		fr.values[ins] = block.NewExtractValue(b.llValue(fr, ins.Tuple), uint64(ins.Index))
+
+	case *ssa.If:
+		block.NewCondBr(b.llValue(fr, ins.Cond), fr.blocks[ins.Block().Succs[0]], fr.blocks[ins.Block().Succs[1]])
+
+	case *ssa.Jump:
+		block.NewBr(fr.blocks[ins.Block().Succs[0]])
+
+	case *ssa.Return:
+		if fr.frame != nil {
+			b.llRuntime(block, "wa_frame_leave", llvmTypes.Void, fr.frame)
+		}
+		switch len(ins.Results) {
+		case 0:
+			block.NewRet(nil)
+		case 1:
+			block.NewRet(b.llValue(fr, ins.Results[0]))
+		default:
+			// 多个返回值打包为结构体
+			var x value.Value = constant.NewUndef(fr.fn.Sig.RetType)
+			for i, r := range ins.Results {
+				x = block.NewInsertValue(x, b.llValue(fr, r), uint64(i))
 			}
+			block.NewRet(x)
+		}
+
+	default:
+		panic(fmt.Sprintf("unsupported instruction: %v", ins))
+	}
+	return block
+}
+
+// 函数调用, typ为调用结果的类型
+func (b *llBuilder) llCallCommon(fr *llFrame, block *ir.Block, call *ssa.CallCommon, typ types.Type, pos token.Pos) (value.Value, *ir.Block) {
+	if call.IsInvoke() {
+		return b.llInvoke(fr, block, call, pos)
+	}
+	if fnBuiltin, ok := call.Value.(*ssa.Builtin); ok {
+		if fnBuiltin.Name() == "ssa:wrapnilchk" {
+			// 值接收者方法的指针包装函数, 指针为nil时panic, 否则返回指针
+			x := b.llValue(fr, call.Args[0])
+			return x, b.llNilCheck(fr, block, x, pos)
+		}
+		return b.llBuiltin(fr, block, fnBuiltin.Name(), call.Args, typ, pos), block
+	}
+	return b.llCall(fr, block, call, pos)
+}
+
+// 内置函数, 没有返回值时结果为nil
+func (b *llBuilder) llBuiltin(fr *llFrame, block *ir.Block, name string, args []ssa.Value, typ types.Type, pos token.Pos) value.Value {
+	switch name {
+	case "print", "println":
+		b.llPrint(fr, block, name == "println", args...)
+		return nil
+	case "len", "cap":
+		return b.llLen(fr, block, name, args[0])
+	case "append":
+		return b.llAppend(fr, block, args, typ)
+	case "delete":
+		b.llMapDelete(fr, block, args, pos)
+		return nil
+	case "panic":
+		// panic语句翻译为Panic指令, 只有延迟调用的panic是内置函数调用
+		b.llPanic(fr, block, args[0], pos)
+		return nil
+	case "recover":
+		return b.llRecover(fr, block)
+	case "real":
+		return block.NewExtractValue(b.llValue(fr, args[0]), 0)
+	case "imag":
+		return block.NewExtractValue(b.llValue(fr, args[0]), 1)
+	case "complex":
+		var z value.Value = constant.NewUndef(b.types.Type(typ))
+		z = block.NewInsertValue(z, b.llValue(fr, args[0]), 0)
+		return block.NewInsertValue(z, b.llValue(fr, args[1]), 1)
+	}
+	panic(fmt.Sprintf("unsupported builtin: %s", name))
+}
+
+// 读取SSA值对应的LLVM值
+func (b *llBuilder) llValue(fr *llFrame, v ssa.Value) value.Value {
+	switch v := v.(type) {
+	case *ssa.Const:
+		return b.llConst(v)
+	case *ssa.Global:
+		return b.globals[v]
+	case *ssa.Function:
+		return b.llFuncValue(v)
+	}
+	if x, ok := fr.values[v]; ok {
+		return x
+	}
+	panic(fmt.Sprintf("no value for %T: %v", v, v.Name()))
+}
+
+// 二元运算, 整数除法和移位运算的检查会拆分基本块
+func (b *llBuilder) llBinOp(fr *llFrame, block *ir.Block, ins *ssa.BinOp) (value.Value, *ir.Block) {
+	x, y := b.llValue(fr, ins.X), b.llValue(fr, ins.Y)
+
+	t, ok := ins.X.Type().Underlying().(*types.Basic)
+	if !ok {
+		return b.llCompare(block, ins, x, y), block
+	}
+
+	isFloat := t.Info()&types.IsFloat != 0
+	isUnsigned := t.Info()&types.IsUnsigned != 0
+
+	if t.Info()&types.IsString != 0 {
+		return b.llStringOp(fr, block, ins.Op, x, y), block
+	}
+	if t.Info()&types.IsComplex != 0 {
+		if ins.Op == token.EQL || ins.Op == token.NEQ {
+			return b.llCompare(block, ins, x, y), block
+		}
+		return b.llComplexOp(fr, block, ins.Op, x, y), block
+	}
+
+	switch ins.Op {
+	case token.ADD:
+		if isFloat {
+			return block.NewFAdd(x, y), block
+		}
+		return block.NewAdd(x, y), block
+	case token.SUB:
+		if isFloat {
+			return block.NewFSub(x, y), block
+		}
+		return block.NewSub(x, y), block
+	case token.MUL:
+		if isFloat {
+			return block.NewFMul(x, y), block
+		}
+		return block.NewMul(x, y), block
+	case token.QUO, token.REM:
+		if isFloat {
+			return block.NewFDiv(x, y), block
+		}
+		return b.llDivide(fr, block, ins, isUnsigned, x, y)
+
+	case token.AND:
+		return block.NewAnd(x, y), block
+	case token.OR:
+		return block.NewOr(x, y), block
+	case token.XOR:
+		return block.NewXor(x, y), block
+	case token.AND_NOT:
+		return block.NewAnd(x, block.NewXor(y, constant.NewInt(x.Type().(*llvmTypes.IntType), -1))), block
+
+	case token.SHL, token.SHR:
+		// 有符号的移位量为负数时panic
+		yt := ins.Y.Type().Underlying().(*types.Basic)
+		if c, isConst := ins.Y.(*ssa.Const); yt.Info()&types.IsUnsigned == 0 && (!isConst || c.Int64() < 0) {
+			fail := block.NewICmp(enum.IPredSLT, y, constant.NewInt(y.Type().(*llvmTypes.IntType), 0))
+			block = b.llCheck(fr, block, fail, "wa_panic_shift", b.llPos(ins.Pos()))
+		}
+		return b.llShift(block, ins.Op, isUnsigned, x, y), block
+
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if isFloat {
+			return block.NewFCmp(llFPred(ins.Op), x, y), block
+		}
+		return block.NewICmp(llIPred(ins.Op, isUnsigned), x, y), block
+	}
+	panic(fmt.Sprintf("unsupported binary op: %v", ins))
+}
+
+// 整数除法和取余
+// 除数为0时panic; 有符号数最小值除以-1时LLVM的结果未定义, Go的商为被除数, 余数为0
+func (b *llBuilder) llDivide(fr *llFrame, block *ir.Block, ins *ssa.BinOp, isUnsigned bool, x, y value.Value) (value.Value, *ir.Block) {
+	it := y.Type().(*llvmTypes.IntType)
+	if c, ok := ins.Y.(*ssa.Const); !ok || c.Int64() == 0 {
+		fail := block.NewICmp(enum.IPredEQ, y, constant.NewInt(it, 0))
+		block = b.llCheck(fr, block, fail, "wa_panic_divide", b.llPos(ins.Pos()))
+	}
+
+	if isUnsigned {
+		if ins.Op == token.QUO {
+			return block.NewUDiv(x, y), block
+		}
+		return block.NewURem(x, y), block
+	}
+
+	minusOne := block.NewICmp(enum.IPredEQ, y, constant.NewInt(it, -1))
+	safeY := block.NewSelect(minusOne, constant.NewInt(it, 1), y)
+	if ins.Op == token.QUO {
+		return block.NewSelect(minusOne, block.NewSub(constant.NewInt(it, 0), x), block.NewSDiv(x, safeY)), block
+	}
+	return block.NewSelect(minusOne, constant.NewInt(it, 0), block.NewSRem(x, safeY)), block
+}
+
+// 字符串的连接和比较
+func (b *llBuilder) llStringOp(fr *llFrame, block *ir.Block, op token.Token, x, y value.Value) value.Value {
+	xp, xn := block.NewExtractValue(x, 0), block.NewExtractValue(x, 1)
+	yp, yn := block.NewExtractValue(y, 0), block.NewExtractValue(y, 1)
+
+	if op == token.ADD {
+		ret := b.llAlloca(fr, b.types.StringType())
+		b.llRuntime(block, "wa_string_concat", llvmTypes.Void, b.llBytePtr(block, ret), xp, xn, yp, yn)
+		return block.NewLoad(b.types.StringType(), ret)
+	}
+
+	r := b.llRuntime(block, "wa_string_compare", llvmTypes.I32, xp, xn, yp, yn)
+	return block.NewICmp(llIPred(op, false), r, constant.NewInt(llvmTypes.I32, 0))
+}
+
+// 复数的四则运算, 按实部和虚部展开
+// 和gc一样, complex64的乘除先转换为complex128计算, 除法调用运行时库处理无穷大和NaN
+func (b *llBuilder) llComplexOp(fr *llFrame, block *ir.Block, op token.Token, x, y value.Value) value.Value {
+	var xr, xi, yr, yi value.Value
+	xr, xi = block.NewExtractValue(x, 0), block.NewExtractValue(x, 1)
+	yr, yi = block.NewExtractValue(y, 0), block.NewExtractValue(y, 1)
+
+	var re, im value.Value
+	switch op {
+	case token.ADD:
+		re, im = block.NewFAdd(xr, yr), block.NewFAdd(xi, yi)
+	case token.SUB:
+		re, im = block.NewFSub(xr, yr), block.NewFSub(xi, yi)
+	case token.MUL, token.QUO:
+		ft := xr.Type()
+		if !ft.Equal(llvmTypes.Double) {
+			xr, xi = block.NewFPExt(xr, llvmTypes.Double), block.NewFPExt(xi, llvmTypes.Double)
+			yr, yi = block.NewFPExt(yr, llvmTypes.Double), block.NewFPExt(yi, llvmTypes.Double)
+		}
+		if op == token.MUL {
+			re = block.NewFSub(block.NewFMul(xr, yr), block.NewFMul(xi, yi))
+			im = block.NewFAdd(block.NewFMul(xr, yi), block.NewFMul(xi, yr))
+		} else {
+			ret := b.llAlloca(fr, llvmTypes.NewArray(2, llvmTypes.Double))
+			p := block.NewGetElementPtr(ret.ElemType, ret, constant.NewInt(llvmTypes.I32, 0), constant.NewInt(llvmTypes.I32, 0))
+			b.llRuntime(block, "wa_complex_div", llvmTypes.Void, p, xr, xi, yr, yi)
+			re = block.NewLoad(llvmTypes.Double, p)
+			im = block.NewLoad(llvmTypes.Double, block.NewGetElementPtr(llvmTypes.Double, p, constant.NewInt(llvmTypes.I32, 1)))
+		}
+		if !ft.Equal(llvmTypes.Double) {
+			re, im = block.NewFPTrunc(re, ft), block.NewFPTrunc(im, ft)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported complex op: %v", op))
+	}
+
+	var z value.Value = constant.NewUndef(x.Type())
+	z = block.NewInsertValue(z, re, 0)
+	return block.NewInsertValue(z, im, 1)
+}
+
+// 非基础类型的==和!=
+// 指针/map/chan/func直接比较地址, 切片只能和nil比较, 结构体和数组逐个比较元素
+// 接口先比较动态类型再比较值, 动态类型不可比较时panic
+func (b *llBuilder) llCompare(block *ir.Block, ins *ssa.BinOp, x, y value.Value) value.Value {
+	eq := b.llEqual(block, ins.X.Type(), x, y, ins.Pos())
+	if ins.Op == token.NEQ {
+		return block.NewXor(eq, constant.True)
+	}
+	return eq
+}
+
+func (b *llBuilder) llEqual(block *ir.Block, t types.Type, x, y value.Value, pos token.Pos) value.Value {
+	switch t := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsFloat != 0:
+			return block.NewFCmp(enum.FPredOEQ, x, y)
+		case t.Info()&types.IsComplex != 0:
+			re := block.NewFCmp(enum.FPredOEQ, block.NewExtractValue(x, 0), block.NewExtractValue(y, 0))
+			im := block.NewFCmp(enum.FPredOEQ, block.NewExtractValue(x, 1), block.NewExtractValue(y, 1))
+			return block.NewAnd(re, im)
+		case t.Info()&types.IsString != 0:
+			xp, xn := block.NewExtractValue(x, 0), block.NewExtractValue(x, 1)
+			yp, yn := block.NewExtractValue(y, 0), block.NewExtractValue(y, 1)
+			r := b.llRuntime(block, "wa_string_compare", llvmTypes.I32, xp, xn, yp, yn)
+			return block.NewICmp(enum.IPredEQ, r, constant.NewInt(llvmTypes.I32, 0))
+		}
+		return block.NewICmp(enum.IPredEQ, x, y)
+
+	case *types.Pointer, *types.Map, *types.Chan, *types.Signature:
+		return block.NewICmp(enum.IPredEQ, x, y)
+
+	case *types.Slice:
+		return block.NewICmp(enum.IPredEQ, block.NewExtractValue(x, 0), block.NewExtractValue(y, 0))
+
+	case *types.Struct:
+		var eq value.Value = constant.True
+		for i := 0; i < t.NumFields(); i++ {
+			fx, fy := block.NewExtractValue(x, uint64(i)), block.NewExtractValue(y, uint64(i))
+			eq = block.NewAnd(eq, b.llEqual(block, t.Field(i).Type(), fx, fy, pos))
+		}
+		return eq
+
+	case *types.Array:
+		var eq value.Value = constant.True
+		for i := int64(0); i < t.Len(); i++ {
+			ex, ey := block.NewExtractValue(x, uint64(i)), block.NewExtractValue(y, uint64(i))
+			eq = block.NewAnd(eq, b.llEqual(block, t.Elem(), ex, ey, pos))
+		}
+		return eq
+
+	case *types.Interface:
+		r := b.llRuntime(block, "wa_ifaceeq", llvmTypes.I32,
+			block.NewExtractValue(x, 0), block.NewExtractValue(x, 1),
+			block.NewExtractValue(y, 0), block.NewExtractValue(y, 1),
+			b.llPos(pos),
+		)
+		return block.NewICmp(enum.IPredNE, r, constant.NewInt(llvmTypes.I32, 0))
+	}
+	panic(fmt.Sprintf("unsupported comparison of %v", t))
+}
+
+// 移位运算
+// LLVM中移位量大于等于位宽时结果未定义, 需要按照Go的规则处理:
+// 左移和逻辑右移的结果为0, 算术右移的结果为符号位
+func (b *llBuilder) llShift(block *ir.Block, op token.Token, isUnsigned bool, x, y value.Value) value.Value {
+	xt := x.Type().(*llvmTypes.IntType)
+	yt := y.Type().(*llvmTypes.IntType)
+
+	// 移位量统一按无符号数处理, 有符号的负数移位量等同于越界
+	if yt.BitSize > xt.BitSize {
+		// 先判断是否越界, 再截断
+		tooBig := block.NewICmp(enum.IPredUGE, y, constant.NewInt(yt, int64(xt.BitSize)))
+		y = block.NewSelect(tooBig, constant.NewInt(yt, int64(xt.BitSize)), y)
+		y = block.NewTrunc(y, xt)
+	} else if yt.BitSize < xt.BitSize {
+		y = block.NewZExt(y, xt)
+	}
+
+	bits := constant.NewInt(xt, int64(xt.BitSize))
+	tooBig := block.NewICmp(enum.IPredUGE, y, bits)
+	switch {
+	case op == token.SHL:
+		r := block.NewShl(x, y)
+		return block.NewSelect(tooBig, constant.NewInt(xt, 0), r)
+	case isUnsigned:
+		r := block.NewLShr(x, y)
+		return block.NewSelect(tooBig, constant.NewInt(xt, 0), r)
+	default:
+		s := block.NewSelect(tooBig, constant.NewInt(xt, int64(xt.BitSize-1)), y)
+		return block.NewAShr(x, s)
+	}
+}
+
+// 一元运算
+func (b *llBuilder) llUnOp(fr *llFrame, block *ir.Block, ins *ssa.UnOp) value.Value {
+	x := b.llValue(fr, ins.X)
+	switch ins.Op {
+	case token.NOT:
+		return block.NewXor(x, constant.True)
+	case token.SUB:
+		if _, ok := x.Type().(*llvmTypes.FloatType); ok {
+			return block.NewFNeg(x)
 		}
+		return block.NewSub(constant.NewInt(x.Type().(*llvmTypes.IntType), 0), x)
+	case token.XOR:
+		return block.NewXor(x, constant.NewInt(x.Type().(*llvmTypes.IntType), -1))
 	}
+	panic(fmt.Sprintf("unsupported unary op: %v", ins))
+}
+
+// 类型转换, 支持数值类型之间以及指针和unsafe.Pointer/uintptr之间的转换
+func (b *llBuilder) llConvert(block *ir.Block, x value.Value, from, to types.Type) value.Value {
+	lt := b.types.Type(to)
+	ft, fok := from.Underlying().(*types.Basic)
+	tt, tok := to.Underlying().(*types.Basic)
+
+	switch {
+	case fok && tok && ft.Info()&types.IsInteger != 0 && tt.Info()&types.IsInteger != 0:
+		return b.llIntCast(block, x, lt.(*llvmTypes.IntType), ft.Info()&types.IsUnsigned != 0)
+
+	case fok && tok && ft.Info()&types.IsInteger != 0 && tt.Info()&types.IsFloat != 0:
+		if ft.Info()&types.IsUnsigned != 0 {
+			return block.NewUIToFP(x, lt)
+		}
+		return block.NewSIToFP(x, lt)
+
+	case fok && tok && ft.Info()&types.IsFloat != 0 && tt.Info()&types.IsInteger != 0:
+		if tt.Info()&types.IsUnsigned != 0 {
+			return block.NewFPToUI(x, lt)
+		}
+		return block.NewFPToSI(x, lt)
+
+	case fok && tok && ft.Info()&types.IsFloat != 0 && tt.Info()&types.IsFloat != 0:
+		fbits := x.Type().(*llvmTypes.FloatType).Kind
+		tbits := lt.(*llvmTypes.FloatType).Kind
+		switch {
+		case fbits == tbits:
+			return x
+		case fbits == llvmTypes.FloatKindFloat:
+			return block.NewFPExt(x, lt)
+		default:
+			return block.NewFPTrunc(x, lt)
+		}
+
+	case fok && ft.Kind() == types.Uintptr && lt.Equal(llvmTypes.I8Ptr):
+		return block.NewIntToPtr(x, lt)
+	case tok && tt.Kind() == types.Uintptr && x.Type().Equal(llvmTypes.I8Ptr):
+		return block.NewPtrToInt(x, lt)
+	}
+
+	if _, ok := x.Type().(*llvmTypes.PointerType); ok {
+		if _, ok := lt.(*llvmTypes.PointerType); ok {
+			return block.NewBitCast(x, lt)
+		}
+	}
+	panic(fmt.Sprintf("unsupported conversion from %v to %v", from, to))
+}
+
+// 底层类型相同的值之间的转换
+// 命名的结构体和字面结构体在LLVM中是不同的类型, 通过内存重新解释
+func (b *llBuilder) llCast(fr *llFrame, block *ir.Block, x value.Value, t llvmTypes.Type) value.Value {
+	if x.Type().Equal(t) {
+		return x
+	}
+	if _, ok := t.(*llvmTypes.PointerType); ok {
+		return block.NewBitCast(x, t)
+	}
+	tmp := b.llAlloca(fr, x.Type())
+	block.NewStore(x, tmp)
+	return block.NewLoad(t, block.NewBitCast(tmp, llvmTypes.NewPointer(t)))
+}
+
+// 比较运算对应的整数谓词
+func llIPred(op token.Token, isUnsigned bool) enum.IPred {
+	switch op {
+	case token.EQL:
+		return enum.IPredEQ
+	case token.NEQ:
+		return enum.IPredNE
+	case token.LSS:
+		if isUnsigned {
+			return enum.IPredULT
+		}
+		return enum.IPredSLT
+	case token.LEQ:
+		if isUnsigned {
+			return enum.IPredULE
+		}
+		return enum.IPredSLE
+	case token.GTR:
+		if isUnsigned {
+			return enum.IPredUGT
+		}
+		return enum.IPredSGT
+	case token.GEQ:
+		if isUnsigned {
+			return enum.IPredUGE
+		}
+		return enum.IPredSGE
+	}
+	panic(fmt.Sprintf("invalid comparison op: %v", op))
+}
+
+// 比较运算对应的浮点数谓词(Go的!=在NaN时为true, 其它比较在NaN时为false)
+func llFPred(op token.Token) enum.FPred {
+	switch op {
+	case token.EQL:
+		return enum.FPredOEQ
+	case token.NEQ:
+		return enum.FPredUNE
+	case token.LSS:
+		return enum.FPredOLT
+	case token.LEQ:
+		return enum.FPredOLE
+	case token.GTR:
+		return enum.FPredOGT
+	case token.GEQ:
+		return enum.FPredOGE
+	}
+	panic(fmt.Sprintf("invalid comparison op: %v", op))
+}
+
+// 常量, nil和其它类型的零值常量对应LLVM的零值
+func (b *llBuilder) llConst(c *ssa.Const) constant.Constant {
+	if c.Value == nil {
+		return llZero(b.types.Type(c.Type()))
+	}
+
+	t := c.Type().Underlying().(*types.Basic)
+	switch {
+	case t.Info()&types.IsString != 0:
+		s := goconstant.StringVal(c.Value)
+		return constant.NewStruct(b.types.StringType(), b.llCStringPtr(s), constant.NewInt(b.types.Int, int64(len(s))))
+	case t.Info()&types.IsBoolean != 0:
+		return constant.NewBool(c.Value.String() == "true")
+	case t.Info()&types.IsInteger != 0:
+		if t.Info()&types.IsUnsigned != 0 {
+			return constant.NewInt(b.types.Type(t).(*llvmTypes.IntType), int64(c.Uint64()))
+		}
+		return constant.NewInt(b.types.Type(t).(*llvmTypes.IntType), c.Int64())
+	case t.Info()&types.IsFloat != 0:
+		return constant.NewFloat(b.types.Type(t).(*llvmTypes.FloatType), c.Float64())
+	case t.Info()&types.IsComplex != 0:
+		st := b.types.Type(t).(*llvmTypes.StructType)
+		ft := st.Fields[0].(*llvmTypes.FloatType)
+		v := c.Complex128()
+		return constant.NewStruct(st, constant.NewFloat(ft, real(v)), constant.NewFloat(ft, imag(v)))
+	}
+	panic(fmt.Sprintf("unsupported constant: %v", c))
+}
+
+// 字符串常量对应的全局变量, 以'\0'结尾
+func (b *llBuilder) llCString(s string) *ir.Global {
+	if g, ok := b.strs[s]; ok {
+		return g
+	}
+	g := b.m.NewGlobalDef(fmt.Sprintf(".str.%d", len(b.strs)), constant.NewCharArrayFromString(s+"\x00"))
+	g.Immutable = true
+	b.strs[s] = g
+	return g
+}
+
+// 全局字符数组的首地址
+func (b *llBuilder) llCStringPtr(s string) constant.Constant {
+	g := b.llCString(s)
+	return constant.NewGetElementPtr(
+		g.Type().(*llvmTypes.PointerType).ElemType, g,
+		constant.NewInt(llvmTypes.I32, 0),
+		constant.NewInt(llvmTypes.I32, 0),
+	)
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 函数值是指向闭包对象的指针, 闭包对象为{code, 自由变量...}
+// code的第一个参数是闭包对象本身, 后面是函数的参数:
+//
+//	有自由变量的函数直接作为code, 闭包对象由MakeClosure在堆上分配
+//	其它函数作为值使用时, 生成忽略上下文参数的包装函数和只读的静态闭包对象
+
+// 闭包对象的Go类型, 用于计算大小
+func llClosureStruct(ssafn *ssa.Function) *types.Struct {
+	fields := []*types.Var{types.NewField(0, nil, "code", types.Typ[types.UnsafePointer], false)}
+	for _, fv := range ssafn.FreeVars {
+		fields = append(fields, types.NewField(0, nil, fv.Name(), fv.Type(), false))
+	}
+	return types.NewStruct(fields, nil)
+}
+
+// 在函数的入口块中读取自由变量
+func (b *llBuilder) llFreeVars(fr *llFrame, ssafn *ssa.Function, ctx value.Value) {
+	st := b.types.Type(llClosureStruct(ssafn))
+	p := fr.entry.NewBitCast(ctx, llvmTypes.NewPointer(st))
+	for i, fv := range ssafn.FreeVars {
+		addr := fr.entry.NewGetElementPtr(st, p,
+			constant.NewInt(llvmTypes.I32, 0),
+			constant.NewInt(llvmTypes.I32, int64(i+1)),
+		)
+		fr.values[fv] = fr.entry.NewLoad(b.types.Type(fv.Type()), addr)
+	}
+}
+
+// 创建闭包对象, 绑定的值复制到闭包对象中
+func (b *llBuilder) llMakeClosure(fr *llFrame, block *ir.Block, ins *ssa.MakeClosure) value.Value {
+	ssafn := ins.Fn.(*ssa.Function)
+	cs := llClosureStruct(ssafn)
+	st := b.types.Type(cs)
+
+	obj := b.llRuntime(block, "wa_alloc", llvmTypes.I8Ptr, constant.NewInt(b.types.Int, b.types.Sizeof(cs)))
+	p := block.NewBitCast(obj, llvmTypes.NewPointer(st))
+	field := func(i int) value.Value {
+		return block.NewGetElementPtr(st, p,
+			constant.NewInt(llvmTypes.I32, 0),
+			constant.NewInt(llvmTypes.I32, int64(i)),
+		)
+	}
+
+	block.NewStore(llBytePtrConst(b.llFuncRef(ssafn)), field(0))
+	for i, binding := range ins.Bindings {
+		block.NewStore(b.llValue(fr, binding), field(i+1))
+	}
+	return obj
+}
+
+// 没有自由变量的函数作为值使用
+func (b *llBuilder) llFuncValue(ssafn *ssa.Function) constant.Constant {
+	if g, ok := b.funcValues[ssafn]; ok {
+		return llBytePtrConst(g)
+	}
+	target := b.llFuncRef(ssafn)
+
+	params := []*ir.Param{ir.NewParam("ctx", llvmTypes.I8Ptr)}
+	var args []value.Value
+	for _, p := range target.Params {
+		param := ir.NewParam(p.Name(), p.Type())
+		params = append(params, param)
+		args = append(args, param)
+	}
+	fn := b.m.NewFunc(target.Name()+"$closure", target.Sig.RetType, params...)
+	entry := fn.NewBlock("entry")
+	b.llDeferForward(entry, fn, ssafn, target)
+	r := entry.NewCall(target, args...)
+	if target.Sig.RetType.Equal(llvmTypes.Void) {
+		entry.NewRet(nil)
+	} else {
+		entry.NewRet(r)
+	}
+
+	g := b.m.NewGlobalDef(target.Name()+"$funcval", constant.NewStruct(
+		llvmTypes.NewStruct(llvmTypes.I8Ptr), llBytePtrConst(fn),
+	))
+	g.Immutable = true
+	b.funcValues[ssafn] = g
+	return llBytePtrConst(g)
+}
+
+// 函数调用, 静态函数直接调用, 函数值通过闭包对象中的code调用
+func (b *llBuilder) llCall(fr *llFrame, block *ir.Block, call *ssa.CallCommon, pos token.Pos) (value.Value, *ir.Block) {
+	var args []value.Value
+	for _, arg := range call.Args {
+		args = append(args, b.llValue(fr, arg))
+	}
+
+	if callee, ok := call.Value.(*ssa.Function); ok && len(callee.FreeVars) == 0 {
+		return block.NewCall(b.llFuncRef(callee), args...), block
+	}
+
+	closure := b.llValue(fr, call.Value)
+	block = b.llNilCheck(fr, block, closure, pos)
+
+	sig := call.Signature()
+	params := []llvmTypes.Type{llvmTypes.I8Ptr}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, b.types.Type(sig.Params().At(i).Type()))
+	}
+	ft := llvmTypes.NewFunc(b.types.ResultType(sig.Results()), params...)
+
+	code := block.NewLoad(llvmTypes.I8Ptr, block.NewBitCast(closure, llvmTypes.NewPointer(llvmTypes.I8Ptr)))
+	args = append([]value.Value{closure}, args...)
+	return block.NewCall(block.NewBitCast(code, llvmTypes.NewPointer(ft)), args...), block
+}
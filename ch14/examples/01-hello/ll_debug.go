@@ -0,0 +1,379 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+	"path/filepath"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// DWARF调试信息
+// 指令的位置和局部变量来自SSA, 类型信息来自lltypes使用的Sizes
+type llDebug struct {
+	b  *llBuilder
+	cu *metadata.DICompileUnit
+
+	files  map[string]*metadata.DIFile
+	locs   map[llDebugLocKey]*metadata.DILocation
+	vars   map[llDebugVarKey]*metadata.DILocalVariable
+	ditype typeutil.Map
+
+	dbgValue   *ir.Func
+	dbgDeclare *ir.Func
+	expr       *metadata.DIExpression
+}
+
+type llDebugLocKey struct {
+	scope        *metadata.DISubprogram
+	line, column int
+}
+
+// 闭包中引用的外层变量在闭包的函数中是另一个局部变量
+type llDebugVarKey struct {
+	scope *metadata.DISubprogram
+	v     *types.Var
+}
+
+func newLLDebug(b *llBuilder, mainPkg *ssa.Package) *llDebug {
+	p := &llDebug{
+		b:     b,
+		files: make(map[string]*metadata.DIFile),
+		locs:  make(map[llDebugLocKey]*metadata.DILocation),
+		vars:  make(map[llDebugVarKey]*metadata.DILocalVariable),
+	}
+
+	// 编译单元使用main包的第一个文件
+	var filename string
+	if fn := mainPkg.Func("main"); fn != nil {
+		filename = b.fset.Position(fn.Pos()).Filename
+	}
+	p.cu = &metadata.DICompileUnit{
+		MetadataID:   -1,
+		Distinct:     true,
+		Language:     enum.DwarfLangGo,
+		File:         p.file(filename),
+		Producer:     "ssago",
+		EmissionKind: enum.EmissionKindFullDebug,
+	}
+	p.add(p.cu)
+	p.expr = &metadata.DIExpression{MetadataID: -1}
+	p.add(p.expr)
+
+	m := b.m
+	m.NamedMetadataDefs["llvm.dbg.cu"] = &metadata.NamedDef{
+		Name:  "llvm.dbg.cu",
+		Nodes: []metadata.Node{p.cu},
+	}
+	dwarfVersion := p.tuple(constant.NewInt(llvmTypes.I32, 7), &metadata.String{Value: "Dwarf Version"}, constant.NewInt(llvmTypes.I32, 4))
+	debugVersion := p.tuple(constant.NewInt(llvmTypes.I32, 2), &metadata.String{Value: "Debug Info Version"}, constant.NewInt(llvmTypes.I32, 3))
+	m.NamedMetadataDefs["llvm.module.flags"] = &metadata.NamedDef{
+		Name:  "llvm.module.flags",
+		Nodes: []metadata.Node{dwarfVersion, debugVersion},
+	}
+
+	md := llvmTypes.Metadata
+	p.dbgValue = m.NewFunc("llvm.dbg.value", llvmTypes.Void, ir.NewParam("", md), ir.NewParam("", md), ir.NewParam("", md))
+	p.dbgDeclare = m.NewFunc("llvm.dbg.declare", llvmTypes.Void, ir.NewParam("", md), ir.NewParam("", md), ir.NewParam("", md))
+	return p
+}
+
+// 注册匿名的元数据节点
+func (p *llDebug) add(md metadata.Definition) {
+	p.b.m.MetadataDefs = append(p.b.m.MetadataDefs, md)
+}
+
+func (p *llDebug) tuple(fields ...metadata.Field) *metadata.Tuple {
+	t := &metadata.Tuple{MetadataID: -1, Fields: fields}
+	p.add(t)
+	return t
+}
+
+// 源文件, 合成的函数没有文件名, 使用编译单元的文件
+func (p *llDebug) file(filename string) *metadata.DIFile {
+	if f, ok := p.files[filename]; ok {
+		return f
+	}
+	if filename == "" && p.cu != nil {
+		return p.cu.File
+	}
+	dir, _ := filepath.Abs(filepath.Dir(filename))
+	f := &metadata.DIFile{
+		MetadataID: -1,
+		Filename:   filepath.Base(filename),
+		Directory:  dir,
+	}
+	p.add(f)
+	p.files[filename] = f
+	return f
+}
+
+// 函数对应的DISubprogram
+func (p *llDebug) subprogram(ssafn *ssa.Function, fn *ir.Func) *metadata.DISubprogram {
+	pos := p.b.fset.Position(ssafn.Pos())
+
+	var sigTypes []metadata.Field
+	if results := ssafn.Signature.Results(); results.Len() == 1 {
+		sigTypes = append(sigTypes, p.typ(results.At(0).Type()))
+	} else {
+		sigTypes = append(sigTypes, &metadata.NullLit{})
+	}
+	for _, param := range ssafn.Params {
+		sigTypes = append(sigTypes, p.typ(param.Type()))
+	}
+	sig := &metadata.DISubroutineType{MetadataID: -1, Types: p.tuple(sigTypes...)}
+	p.add(sig)
+
+	sp := &metadata.DISubprogram{
+		MetadataID:  -1,
+		Distinct:    true,
+		Scope:       p.file(pos.Filename),
+		Name:        ssafn.Name(),
+		LinkageName: fn.Name(),
+		File:        p.file(pos.Filename),
+		Line:        int64(pos.Line),
+		Type:        sig,
+		ScopeLine:   int64(pos.Line),
+		SPFlags:     enum.DISPFlagDefinition,
+		Unit:        p.cu,
+	}
+	if ssafn.Synthetic != "" {
+		sp.Flags = enum.DIFlagArtificial
+	}
+	p.add(sp)
+	fn.Metadata = append(fn.Metadata, &metadata.Attachment{Name: "dbg", Node: sp})
+	return sp
+}
+
+// 源码位置对应的DILocation, 无效的位置对应第0行
+func (p *llDebug) location(sp *metadata.DISubprogram, pos token.Pos) *metadata.DILocation {
+	position := p.b.fset.Position(pos)
+	key := llDebugLocKey{sp, position.Line, position.Column}
+	if loc, ok := p.locs[key]; ok {
+		return loc
+	}
+	loc := &metadata.DILocation{
+		MetadataID: -1,
+		Line:       int64(position.Line),
+		Column:     int64(position.Column),
+		Scope:      sp,
+	}
+	p.add(loc)
+	p.locs[key] = loc
+	return loc
+}
+
+// 局部变量对应的DILocalVariable, arg为参数的序号(从1开始), 普通变量为0
+// t为变量的类型, 泛型函数的实例中是替换类型参数之后的类型
+func (p *llDebug) variable(sp *metadata.DISubprogram, v *types.Var, t types.Type, arg int) *metadata.DILocalVariable {
+	key := llDebugVarKey{sp, v}
+	if dv, ok := p.vars[key]; ok {
+		return dv
+	}
+	pos := p.b.fset.Position(v.Pos())
+	dv := &metadata.DILocalVariable{
+		MetadataID: -1,
+		Scope:      sp,
+		Name:       v.Name(),
+		Arg:        uint64(arg),
+		File:       p.file(pos.Filename),
+		Line:       int64(pos.Line),
+		Type:       p.typ(t),
+	}
+	p.add(dv)
+	p.vars[key] = dv
+	return dv
+}
+
+// 变量的值, 通过llvm.dbg.value描述
+func (p *llDebug) value(block *ir.Block, dv *metadata.DILocalVariable, x value.Value) {
+	block.NewCall(p.dbgValue,
+		&metadata.Value{Value: x},
+		&metadata.Value{Value: dv},
+		&metadata.Value{Value: p.expr},
+	)
+}
+
+// 变量的地址, 通过llvm.dbg.declare描述, 只用于栈上的变量
+func (p *llDebug) declare(block *ir.Block, dv *metadata.DILocalVariable, x *ir.InstAlloca) {
+	block.NewCall(p.dbgDeclare,
+		&metadata.Value{Value: x},
+		&metadata.Value{Value: dv},
+		&metadata.Value{Value: p.expr},
+	)
+}
+
+// 变量的值或地址变化时生成调试信息, 对应SSA的DebugRef指令
+func (p *llDebug) debugRef(fr *llFrame, block *ir.Block, ref *ssa.DebugRef) {
+	v, ok := ref.Object().(*types.Var)
+	if !ok || v.Pkg() == nil || v.Parent() == v.Pkg().Scope() || v.Name() == "_" {
+		return // 只处理局部变量
+	}
+	if _, ok := ref.X.(*ssa.Global); ok {
+		return
+	}
+
+	// 泛型函数实例化之后变量的类型是类型参数, 使用SSA值中替换后的类型
+	t := ref.X.Type()
+	if ref.IsAddr {
+		t = t.Underlying().(*types.Pointer).Elem()
+	}
+	x := p.b.llValue(fr, ref.X)
+	dv := p.variable(fr.sp, v, t, 0)
+	if ref.IsAddr {
+		if alloca, ok := x.(*ir.InstAlloca); ok {
+			p.declare(block, dv, alloca)
+		}
+		return
+	}
+	p.value(block, dv, x)
+}
+
+// Go类型对应的DWARF类型
+func (p *llDebug) typ(t types.Type) metadata.Field {
+	if x, ok := p.ditype.At(t).(metadata.Field); ok {
+		return x
+	}
+
+	sizes := p.b.types.Target().Sizes
+	name := types.TypeString(t, func(pkg *types.Package) string { return pkg.Name() })
+	bits := uint64(sizes.Sizeof(t) * 8)
+
+	var x metadata.Definition
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			return p.structType(t, name, []string{"str", "len"}, []types.Type{
+				types.NewPointer(types.Typ[types.Uint8]), types.Typ[types.Int],
+			})
+		case u.Kind() == types.UnsafePointer:
+			x = p.pointerType(name, &metadata.NullLit{})
+		default:
+			x = &metadata.DIBasicType{
+				MetadataID: -1,
+				Tag:        enum.DwarfTagBaseType,
+				Name:       name,
+				Size:       bits,
+				Encoding:   llDwarfEncoding(u),
+			}
+		}
+
+	case *types.Pointer:
+		// 先注册指针类型再生成元素类型, 支持递归的类型
+		ptr := p.pointerType(name, nil)
+		p.add(ptr)
+		p.ditype.Set(t, ptr)
+		ptr.BaseType = p.typ(u.Elem())
+		return ptr
+
+	case *types.Struct:
+		var names []string
+		var fieldTypes []types.Type
+		for i := 0; i < u.NumFields(); i++ {
+			names = append(names, u.Field(i).Name())
+			fieldTypes = append(fieldTypes, u.Field(i).Type())
+		}
+		return p.structType(t, name, names, fieldTypes)
+
+	case *types.Slice:
+		return p.structType(t, name, []string{"array", "len", "cap"}, []types.Type{
+			types.NewPointer(u.Elem()), types.Typ[types.Int], types.Typ[types.Int],
+		})
+
+	case *types.Array:
+		subrange := &metadata.DISubrange{MetadataID: -1, Count: metadata.IntLit(u.Len())}
+		p.add(subrange)
+		x = &metadata.DICompositeType{
+			MetadataID: -1,
+			Tag:        enum.DwarfTagArrayType,
+			BaseType:   p.typ(u.Elem()),
+			Size:       bits,
+			Elements:   p.tuple(subrange),
+		}
+
+	case *types.Interface:
+		return p.structType(t, name, []string{"tab", "data"}, []types.Type{
+			types.Typ[types.UnsafePointer], types.Typ[types.UnsafePointer],
+		})
+
+	default:
+		// map/chan/func对应运行时的指针
+		x = p.pointerType(name, &metadata.NullLit{})
+	}
+
+	p.add(x)
+	p.ditype.Set(t, x)
+	return x
+}
+
+func (p *llDebug) pointerType(name string, elem metadata.Field) *metadata.DIDerivedType {
+	x := &metadata.DIDerivedType{
+		MetadataID: -1,
+		Tag:        enum.DwarfTagPointerType,
+		Name:       name,
+		BaseType:   elem,
+		Size:       uint64(p.b.types.Sizeof(types.Typ[types.UnsafePointer]) * 8),
+	}
+	return x
+}
+
+// 结构体类型, 字段的偏移量和类型检查使用的Sizes一致
+func (p *llDebug) structType(t types.Type, name string, names []string, fieldTypes []types.Type) metadata.Field {
+	sizes := p.b.types.Target().Sizes
+	x := &metadata.DICompositeType{
+		MetadataID: -1,
+		Tag:        enum.DwarfTagStructureType,
+		Name:       name,
+		Size:       uint64(sizes.Sizeof(t) * 8),
+	}
+	p.add(x)
+	p.ditype.Set(t, x)
+
+	var vars []*types.Var
+	for i := range names {
+		vars = append(vars, types.NewField(token.NoPos, nil, names[i], fieldTypes[i], false))
+	}
+	offsets := sizes.Offsetsof(vars)
+
+	var members []metadata.Field
+	for i, v := range vars {
+		member := &metadata.DIDerivedType{
+			MetadataID: -1,
+			Tag:        enum.DwarfTagMember,
+			Name:       v.Name(),
+			Scope:      x,
+			BaseType:   p.typ(v.Type()),
+			Size:       uint64(sizes.Sizeof(v.Type()) * 8),
+			Offset:     uint64(offsets[i] * 8),
+		}
+		p.add(member)
+		members = append(members, member)
+	}
+	x.Elements = p.tuple(members...)
+	return x
+}
+
+// 基础类型的DWARF编码
+func llDwarfEncoding(t *types.Basic) enum.DwarfAttEncoding {
+	switch {
+	case t.Info()&types.IsBoolean != 0:
+		return enum.DwarfAttEncodingBoolean
+	case t.Info()&types.IsUnsigned != 0:
+		return enum.DwarfAttEncodingUnsigned
+	case t.Info()&types.IsInteger != 0:
+		return enum.DwarfAttEncodingSigned
+	case t.Info()&types.IsFloat != 0:
+		return enum.DwarfAttEncodingFloat
+	case t.Info()&types.IsComplex != 0:
+		return enum.DwarfAttEncodingComplexFloat
+	}
+	return enum.DwarfAttEncodingUnsigned
+}
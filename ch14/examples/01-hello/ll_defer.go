@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+)
+
+// defer/panic/recover基于运行时的栈帧链表和setjmp/longjmp实现:
+//
+//	有defer语句的函数在入口块注册栈帧, 然后调用_setjmp保存恢复点
+//	defer语句将延迟调用加入栈帧, RunDefers按后进先出的顺序执行
+//	panic时运行时执行各个栈帧的延迟调用, recover之后longjmp回到恢复点,
+//	_setjmp第二次返回时跳转到SSA的recover块, 读取命名返回值后返回
+//
+// recover只在延迟调用直接调用的函数中有效:
+//
+//	延迟调用的包装函数在调用之前用wa_defer_arm登记被调用的函数
+//	调用recover的函数在入口用wa_defer_enter检查登记的是否是自己, 结果传给wa_recover
+//	函数值和接口方法的包装函数用wa_defer_forward把登记转交给被包装的函数
+
+// 在入口块中注册栈帧并保存恢复点
+func (b *llBuilder) llDeferFrame(fr *llFrame) {
+	fr.frame = b.llRuntime(fr.entry, "wa_frame_enter", llvmTypes.I8Ptr)
+	r := b.llRuntime(fr.entry, "_setjmp", llvmTypes.I32, fr.frame)
+	r.Callee.(*ir.Func).FuncAttrs = []ir.FuncAttribute{enum.FuncAttrReturnsTwice}
+	fr.recovering = fr.entry.NewICmp(enum.IPredNE, r, constant.NewInt(llvmTypes.I32, 0))
+}
+
+// 进入recover块之前从副本中重新读取变量的地址, 返回需要在recover块之后恢复的值
+func (b *llBuilder) llReloadSlots(fr *llFrame, block *ir.Block) map[ssa.Value]value.Value {
+	saved := make(map[ssa.Value]value.Value)
+	for alloc, slot := range fr.slots {
+		saved[alloc] = fr.values[alloc]
+		x := block.NewLoad(slot.ElemType, slot)
+		x.Volatile = true
+		fr.values[alloc] = x
+	}
+	return saved
+}
+
+// defer语句
+// 函数值和参数在执行defer语句时求值, 保存在堆上的上下文中, 由生成的包装函数读取后完成调用
+func (b *llBuilder) llDefer(fr *llFrame, block *ir.Block, ins *ssa.Defer) {
+	call := ins.Common()
+
+	var captured []ssa.Value
+	capture := func(v ssa.Value) {
+		switch v.(type) {
+		case *ssa.Const, *ssa.Global, *ssa.Function, *ssa.Builtin:
+			return
+		}
+		captured = append(captured, v)
+	}
+	capture(call.Value)
+	for _, arg := range call.Args {
+		capture(arg)
+	}
+
+	var fields []*types.Var
+	for _, v := range captured {
+		fields = append(fields, types.NewField(token.NoPos, nil, v.Name(), v.Type(), false))
+	}
+	ctxType := types.NewStruct(fields, nil)
+	st := b.types.Type(ctxType)
+
+	var ctx value.Value = constant.NewNull(llvmTypes.I8Ptr)
+	if len(captured) > 0 {
+		ctx = b.llRuntime(block, "wa_alloc", llvmTypes.I8Ptr, constant.NewInt(b.types.Int, b.types.Sizeof(ctxType)))
+		p := block.NewBitCast(ctx, llvmTypes.NewPointer(st))
+		for i, v := range captured {
+			addr := block.NewGetElementPtr(st, p,
+				constant.NewInt(llvmTypes.I32, 0),
+				constant.NewInt(llvmTypes.I32, int64(i)),
+			)
+			block.NewStore(b.llValue(fr, v), addr)
+		}
+	}
+
+	fn := b.llDeferThunk(fr, ins, captured, st)
+	b.llRuntime(block, "wa_defer_push", llvmTypes.Void, fr.frame, llBytePtrConst(fn), ctx)
+}
+
+// 延迟调用的包装函数, 参数为defer语句保存的上下文
+func (b *llBuilder) llDeferThunk(fr *llFrame, ins *ssa.Defer, captured []ssa.Value, st llvmTypes.Type) *ir.Func {
+	ctx := ir.NewParam("ctx", llvmTypes.I8Ptr)
+	fn := b.m.NewFunc(fmt.Sprintf("%s$defer%d", fr.fn.Name(), fr.ndefers), llvmTypes.Void, ctx)
+	fr.ndefers++
+
+	thunk := &llFrame{
+		fn:     fn,
+		values: make(map[ssa.Value]value.Value),
+	}
+	thunk.entry = fn.NewBlock("entry")
+	body := fn.NewBlock("call")
+
+	p := thunk.entry.NewBitCast(ctx, llvmTypes.NewPointer(st))
+	for i, v := range captured {
+		addr := thunk.entry.NewGetElementPtr(st, p,
+			constant.NewInt(llvmTypes.I32, 0),
+			constant.NewInt(llvmTypes.I32, int64(i)),
+		)
+		thunk.values[v] = thunk.entry.NewLoad(b.types.Type(v.Type()), addr)
+	}
+	thunk.entry.NewBr(body)
+
+	v, body := b.llCallCommon(thunk, body, ins.Common(), nil, ins.Pos())
+	if call, ok := v.(*ir.InstCall); ok {
+		b.llDeferArm(body, call)
+	}
+	body.NewRet(nil)
+	return fn
+}
+
+// 在延迟调用的调用指令之前登记被调用的函数
+// 函数指针在调用指令之前已经计算好, 登记的指令生成在块的末尾后移到调用指令之前
+func (b *llBuilder) llDeferArm(block *ir.Block, call *ir.InstCall) {
+	n := len(block.Insts)
+	if n == 0 || block.Insts[n-1] != call {
+		panic(fmt.Sprintf("deferred call is not the last instruction: %v", call))
+	}
+	b.llRuntime(block, "wa_defer_arm", llvmTypes.Void, b.llBytePtr(block, call.Callee))
+	insts := append(block.Insts[:n-1:n-1], block.Insts[n:]...)
+	block.Insts = append(insts, call)
+}
+
+// 函数中是否直接调用了recover, defer recover()不算
+func llCallsRecover(ssafn *ssa.Function) bool {
+	for _, blk := range ssafn.Blocks {
+		for _, ins := range blk.Instrs {
+			call, ok := ins.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			if fn, ok := call.Call.Value.(*ssa.Builtin); ok && fn.Name() == "recover" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 调用recover的函数在入口检查自己是否被延迟调用直接调用
+func (b *llBuilder) llRecoverEnter(fr *llFrame) {
+	fr.canRecover = b.llRuntime(fr.entry, "wa_defer_enter", llvmTypes.I32, llBytePtrConst(fr.fn))
+}
+
+// 包装函数被延迟调用时, 把登记转交给调用recover的被包装函数
+func (b *llBuilder) llDeferForward(block *ir.Block, wrapper *ir.Func, ssafn *ssa.Function, target *ir.Func) {
+	if llCallsRecover(ssafn) {
+		b.llRuntime(block, "wa_defer_forward", llvmTypes.Void, llBytePtrConst(wrapper), llBytePtrConst(target))
+	}
+}
+
+// panic(x), x为interface{}类型的值
+func (b *llBuilder) llPanic(fr *llFrame, block *ir.Block, x ssa.Value, pos token.Pos) {
+	v := b.llValue(fr, x)
+	b.llRuntime(block, "wa_panic", llvmTypes.Void,
+		block.NewExtractValue(v, 0),
+		block.NewExtractValue(v, 1),
+		b.llPos(pos),
+	)
+}
+
+// recover(), 不在panic过程中或者不是被延迟调用直接调用时返回nil
+func (b *llBuilder) llRecover(fr *llFrame, block *ir.Block) value.Value {
+	t := llvmTypes.NewStruct(llvmTypes.I8Ptr, llvmTypes.I8Ptr)
+	ret := b.llAlloca(fr, t)
+	var can value.Value = constant.NewInt(llvmTypes.I32, 0)
+	if fr.canRecover != nil {
+		can = fr.canRecover
+	}
+	b.llRuntime(block, "wa_recover", llvmTypes.Void, b.llBytePtr(block, ret), can)
+	return block.NewLoad(t, ret)
+}
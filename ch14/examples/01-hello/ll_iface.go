@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 接口值为{itab, data}两个指针, 内存布局和runtime.h中的定义一致:
+//
+//	itab:  {wa_type* type, void* fun[n]}, 方法按types.NewMethodSet的顺序排列
+//	data:  指针形状的值直接保存, 其它值复制到堆上
+//
+// 方法表中的函数是接口调用入口, 第一个参数是数据指针, 转换为接收者后调用方法
+
+// 类型描述符的标志位
+const (
+	llTypeDirect     = 1
+	llTypeComparable = 2
+	llTypeBasic      = 4
+)
+
+// 类型的种类, 运行时根据种类输出panic的值
+func llTypeKind(t types.Type) int64 {
+	bt, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch {
+	case bt.Info()&types.IsBoolean != 0:
+		return 1
+	case bt.Info()&types.IsInteger != 0 && bt.Info()&types.IsUnsigned == 0:
+		return 2
+	case bt.Info()&types.IsInteger != 0:
+		return 3
+	case bt.Info()&types.IsFloat != 0:
+		return 4
+	case bt.Info()&types.IsComplex != 0:
+		return 5
+	case bt.Info()&types.IsString != 0:
+		return 6
+	}
+	return 0
+}
+
+// 运行时输出的类型名, 和gc的格式一致
+func llTypeName(t types.Type) string {
+	s := types.TypeString(types.Unalias(t), func(p *types.Package) string { return p.Name() })
+	return strings.ReplaceAll(s, "interface{}", "interface {}")
+}
+
+// 值是否直接保存在接口的数据指针中
+func llIsDirect(t types.Type) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Pointer, *types.Map, *types.Chan, *types.Signature:
+		return true
+	case *types.Basic:
+		return t.Kind() == types.UnsafePointer
+	}
+	return false
+}
+
+// 指针转换为i8*类型的常量
+func llBytePtrConst(x constant.Constant) constant.Constant {
+	if x.Type().Equal(llvmTypes.I8Ptr) {
+		return x
+	}
+	return constant.NewBitCast(x, llvmTypes.I8Ptr)
+}
+
+// 类型描述符的结构体类型, 对应runtime.h中的wa_type
+func (b *llBuilder) llTypeDescType() *llvmTypes.StructType {
+	if b.typeDescT == nil {
+		b.typeDescT = llvmTypes.NewStruct(
+			llvmTypes.I8Ptr, b.types.Int, b.types.Int, b.types.Int, llvmTypes.I8Ptr, llvmTypes.I8Ptr, b.types.Int, llvmTypes.I8Ptr,
+		)
+		b.m.NewTypeDef("wa_type", b.typeDescT)
+	}
+	return b.typeDescT
+}
+
+// 具体类型的描述符, 方法表包含类型的方法集中的全部方法
+func (b *llBuilder) llTypeDesc(t types.Type) *ir.Global {
+	if g, ok := b.typeDescs.At(t).(*ir.Global); ok {
+		return g
+	}
+
+	// 先注册, 方法的函数体中可能再次引用自身的描述符
+	name := llTypeName(t)
+	g := b.m.NewGlobalDef("type."+name, constant.NewZeroInitializer(b.llTypeDescType()))
+	g.Immutable = true
+	b.typeDescs.Set(t, g)
+
+	var flags int64
+	if llIsDirect(t) {
+		flags |= llTypeDirect
+	}
+	if _, ok := types.Unalias(t).(*types.Basic); ok {
+		flags |= llTypeBasic
+	}
+	var equal, hash constant.Constant = constant.NewNull(llvmTypes.I8Ptr), constant.NewNull(llvmTypes.I8Ptr)
+	if types.Comparable(t) {
+		flags |= llTypeComparable
+		if flags&llTypeDirect == 0 {
+			equal = llBytePtrConst(b.llEqualFunc(t))
+			hash = llBytePtrConst(b.llHashFunc(t))
+		}
+	}
+
+	var methods constant.Constant = constant.NewNull(llvmTypes.I8Ptr)
+	mset := types.NewMethodSet(t)
+	if mset.Len() > 0 {
+		mt := llvmTypes.NewStruct(llvmTypes.I8Ptr, llvmTypes.I8Ptr)
+		var elems []constant.Constant
+		for i := 0; i < mset.Len(); i++ {
+			sel := mset.At(i)
+			elems = append(elems, constant.NewStruct(mt,
+				b.llCStringPtr(sel.Obj().Id()),
+				llBytePtrConst(b.llIfaceThunk(t, sel)),
+			))
+		}
+		tab := b.m.NewGlobalDef("methods."+name, constant.NewArray(llvmTypes.NewArray(uint64(len(elems)), mt), elems...))
+		tab.Immutable = true
+		methods = llBytePtrConst(tab)
+	}
+
+	g.Init = constant.NewStruct(b.llTypeDescType(),
+		b.llCStringPtr(name),
+		constant.NewInt(b.types.Int, b.types.Sizeof(t)),
+		constant.NewInt(b.types.Int, flags),
+		constant.NewInt(b.types.Int, llTypeKind(t)),
+		equal,
+		hash,
+		constant.NewInt(b.types.Int, int64(mset.Len())),
+		methods,
+	)
+	return g
+}
+
+// 接口类型的描述符, 对应runtime.h中的wa_itype
+func (b *llBuilder) llIType(t types.Type) *ir.Global {
+	if g, ok := b.itypes.At(t).(*ir.Global); ok {
+		return g
+	}
+
+	name := llTypeName(t)
+	mset := types.NewMethodSet(t)
+	var names []constant.Constant
+	for i := 0; i < mset.Len(); i++ {
+		names = append(names, b.llCStringPtr(mset.At(i).Obj().Id()))
+	}
+	init := constant.NewStruct(
+		llvmTypes.NewStruct(llvmTypes.I8Ptr, b.types.Int, llvmTypes.NewArray(uint64(len(names)), llvmTypes.I8Ptr)),
+		b.llCStringPtr(name),
+		constant.NewInt(b.types.Int, int64(len(names))),
+		constant.NewArray(llvmTypes.NewArray(uint64(len(names)), llvmTypes.I8Ptr), names...),
+	)
+	g := b.m.NewGlobalDef("itype."+name, init)
+	g.Immutable = true
+	b.itypes.Set(t, g)
+	return g
+}
+
+// 具体类型t实现接口iface的方法表, 在编译时生成
+func (b *llBuilder) llItab(iface, t types.Type) *ir.Global {
+	desc, itype := b.llTypeDesc(t), b.llIType(iface)
+	key := [2]*ir.Global{itype, desc}
+	if g, ok := b.itabs[key]; ok {
+		return g
+	}
+
+	imset, mset := types.NewMethodSet(iface), types.NewMethodSet(t)
+	var fns []constant.Constant
+	for i := 0; i < imset.Len(); i++ {
+		m := imset.At(i).Obj()
+		sel := mset.Lookup(m.Pkg(), m.Name())
+		if sel == nil {
+			panic(fmt.Sprintf("%v does not implement %v: missing method %s", t, iface, m.Name()))
+		}
+		fns = append(fns, llBytePtrConst(b.llIfaceThunk(t, sel)))
+	}
+
+	at := llvmTypes.NewArray(uint64(len(fns)), llvmTypes.I8Ptr)
+	init := constant.NewStruct(llvmTypes.NewStruct(llvmTypes.I8Ptr, at),
+		llBytePtrConst(desc),
+		constant.NewArray(at, fns...),
+	)
+	g := b.m.NewGlobalDef("itab."+llTypeName(t)+","+llTypeName(iface), init)
+	g.Immutable = true
+	b.itabs[key] = g
+	return g
+}
+
+// 方法的接口调用入口, 将数据指针转换为接收者后调用方法
+func (b *llBuilder) llIfaceThunk(t types.Type, sel *types.Selection) *ir.Func {
+	ssafn := b.prog.MethodValue(sel)
+	if fn, ok := b.thunks[ssafn]; ok {
+		return fn
+	}
+	target := b.llFuncRef(ssafn)
+
+	params := []*ir.Param{ir.NewParam("data", llvmTypes.I8Ptr)}
+	for _, p := range target.Params[1:] {
+		params = append(params, ir.NewParam(p.Name(), p.Type()))
+	}
+	fn := b.m.NewFunc(target.Name()+"$iface", target.Sig.RetType, params...)
+	b.thunks[ssafn] = fn
+
+	entry := fn.NewBlock("entry")
+	b.llDeferForward(entry, fn, ssafn, target)
+	args := []value.Value{b.llUnbox(entry, t, params[0])}
+	for _, p := range params[1:] {
+		args = append(args, p)
+	}
+	r := entry.NewCall(target, args...)
+	if target.Sig.RetType.Equal(llvmTypes.Void) {
+		entry.NewRet(nil)
+	} else {
+		entry.NewRet(r)
+	}
+	return fn
+}
+
+// 可比较类型的比较函数, 用于比较接口中非直接保存的值以及map的键
+func (b *llBuilder) llEqualFunc(t types.Type) *ir.Func {
+	if fn, ok := b.equalFuncs.At(t).(*ir.Func); ok {
+		return fn
+	}
+	lt := b.types.Type(t)
+	px, py := ir.NewParam("x", llvmTypes.I8Ptr), ir.NewParam("y", llvmTypes.I8Ptr)
+	fn := b.m.NewFunc("eq."+llTypeName(t), llvmTypes.I32, px, py)
+	b.equalFuncs.Set(t, fn)
+
+	entry := fn.NewBlock("entry")
+	x := entry.NewLoad(lt, entry.NewBitCast(px, llvmTypes.NewPointer(lt)))
+	y := entry.NewLoad(lt, entry.NewBitCast(py, llvmTypes.NewPointer(lt)))
+	entry.NewRet(entry.NewZExt(b.llEqual(entry, t, x, y, token.NoPos), llvmTypes.I32))
+	return fn
+}
+
+// 值转换为接口的数据指针
+func (b *llBuilder) llBox(block *ir.Block, t types.Type, x value.Value) value.Value {
+	if llIsDirect(t) {
+		return b.llBytePtr(block, x)
+	}
+	p := b.llRuntime(block, "wa_alloc", llvmTypes.I8Ptr, constant.NewInt(b.types.Int, b.types.Sizeof(t)))
+	block.NewStore(x, block.NewBitCast(p, llvmTypes.NewPointer(x.Type())))
+	return p
+}
+
+// 从接口的数据指针中取出值
+func (b *llBuilder) llUnbox(block *ir.Block, t types.Type, data value.Value) value.Value {
+	lt := b.types.Type(t)
+	if llIsDirect(t) {
+		if lt.Equal(llvmTypes.I8Ptr) {
+			return data
+		}
+		return block.NewBitCast(data, lt)
+	}
+	return block.NewLoad(lt, block.NewBitCast(data, llvmTypes.NewPointer(lt)))
+}
+
+// 具体类型的值转换为接口
+func (b *llBuilder) llMakeInterface(fr *llFrame, block *ir.Block, ins *ssa.MakeInterface) value.Value {
+	t := ins.X.Type()
+	tab := llBytePtrConst(b.llItab(ins.Type(), t))
+	data := b.llBox(block, t, b.llValue(fr, ins.X))
+	return llIface(block, tab, data)
+}
+
+// 由itab和数据指针组成接口值
+func llIface(block *ir.Block, tab, data value.Value) value.Value {
+	var x value.Value = constant.NewUndef(llvmTypes.NewStruct(llvmTypes.I8Ptr, llvmTypes.I8Ptr))
+	x = block.NewInsertValue(x, tab, 0)
+	return block.NewInsertValue(x, data, 1)
+}
+
+// 接口之间的转换, 运行时根据动态类型查找目标接口的方法表
+func (b *llBuilder) llChangeInterface(fr *llFrame, block *ir.Block, ins *ssa.ChangeInterface) value.Value {
+	x := b.llValue(fr, ins.X)
+	tab := b.llRuntime(block, "wa_convI2I", llvmTypes.I8Ptr,
+		llBytePtrConst(b.llIType(ins.Type())),
+		block.NewExtractValue(x, 0),
+	)
+	return llIface(block, tab, block.NewExtractValue(x, 1))
+}
+
+// 通过方法表调用接口的方法, 接口为nil时panic
+func (b *llBuilder) llInvoke(fr *llFrame, block *ir.Block, call *ssa.CallCommon, pos token.Pos) (value.Value, *ir.Block) {
+	x := b.llValue(fr, call.Value)
+	tab, data := block.NewExtractValue(x, 0), block.NewExtractValue(x, 1)
+	block = b.llNilCheck(fr, block, tab, pos)
+
+	index := -1
+	mset := types.NewMethodSet(call.Value.Type())
+	for i := 0; i < mset.Len(); i++ {
+		if mset.At(i).Obj().Id() == call.Method.Id() {
+			index = i
+		}
+	}
+	if index < 0 {
+		panic(fmt.Sprintf("method %s not found in %v", call.Method.Name(), call.Value.Type()))
+	}
+
+	sig := call.Method.Type().(*types.Signature)
+	params := []llvmTypes.Type{llvmTypes.I8Ptr}
+	args := []value.Value{data}
+	for i, arg := range call.Args {
+		params = append(params, b.types.Type(sig.Params().At(i).Type()))
+		args = append(args, b.llValue(fr, arg))
+	}
+	ft := llvmTypes.NewFunc(b.types.ResultType(sig.Results()), params...)
+
+	// 方法表的第0项是动态类型
+	slots := block.NewBitCast(tab, llvmTypes.NewPointer(llvmTypes.I8Ptr))
+	p := block.NewLoad(llvmTypes.I8Ptr, block.NewGetElementPtr(llvmTypes.I8Ptr, slots, constant.NewInt(llvmTypes.I32, int64(index+1))))
+	return block.NewCall(block.NewBitCast(p, llvmTypes.NewPointer(ft)), args...), block
+}
+
+// 类型断言, 类型switch也会翻译为一系列带ok的类型断言
+// 断言为具体类型时比较动态类型的描述符, 断言为接口时由运行时查找方法表
+func (b *llBuilder) llTypeAssert(fr *llFrame, block *ir.Block, ins *ssa.TypeAssert) (value.Value, *ir.Block) {
+	x := b.llValue(fr, ins.X)
+	tab, data := block.NewExtractValue(x, 0), block.NewExtractValue(x, 1)
+	src, want := llTypeName(ins.X.Type()), llTypeName(ins.AssertedType)
+
+	var v, ok value.Value
+	if _, isIface := ins.AssertedType.Underlying().(*types.Interface); isIface {
+		canfail := int64(0)
+		if ins.CommaOk {
+			canfail = 1
+		}
+		newtab := b.llRuntime(block, "wa_assertI2I", llvmTypes.I8Ptr,
+			llBytePtrConst(b.llIType(ins.AssertedType)),
+			tab,
+			b.llCStringPtr(src),
+			constant.NewInt(llvmTypes.I32, canfail),
+			b.llPos(ins.Pos()),
+		)
+		ok = block.NewICmp(enum.IPredNE, newtab, constant.NewNull(llvmTypes.I8Ptr))
+		v = llIface(block, newtab, block.NewSelect(ok, data, constant.NewNull(llvmTypes.I8Ptr)))
+	} else {
+		have := b.llRuntime(block, "wa_typeof", llvmTypes.I8Ptr, tab)
+		ok = block.NewICmp(enum.IPredEQ, have, llBytePtrConst(b.llTypeDesc(ins.AssertedType)))
+		if !ins.CommaOk {
+			block = b.llCheck(fr, block, block.NewXor(ok, constant.True), "wa_panic_typeassert",
+				tab, b.llCStringPtr(src), b.llCStringPtr(want), b.llPos(ins.Pos()),
+			)
+			return b.llUnbox(block, ins.AssertedType, data), block
+		}
+
+		// 断言失败时数据指针可能指向其它类型的值, 不能读取
+		lt := b.types.Type(ins.AssertedType)
+		if llIsDirect(ins.AssertedType) {
+			v = block.NewSelect(ok, b.llUnbox(block, ins.AssertedType, data), llZero(lt))
+		} else {
+			zero := b.llAlloca(fr, lt)
+			block.NewStore(llZero(lt), zero)
+			p := block.NewSelect(ok, block.NewBitCast(data, zero.Type()), zero)
+			v = block.NewLoad(lt, p)
+		}
+	}
+
+	if !ins.CommaOk {
+		return v, block
+	}
+	var r value.Value = constant.NewUndef(b.types.Type(ins.Type()))
+	r = block.NewInsertValue(r, v, 0)
+	return block.NewInsertValue(r, ok, 1), block
+}
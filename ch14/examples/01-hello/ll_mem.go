@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 切片运算的种类, 和runtime.h中的定义一致
+const (
+	llSlice2      = 0
+	llSlice3      = 1
+	llSliceString = 2
+)
+
+// 在函数入口块分配栈上的变量
+// 放在入口块中的alloca只执行一次, 循环中的变量不会反复增长栈空间
+func (b *llBuilder) llAlloca(fr *llFrame, t llvmTypes.Type) *ir.InstAlloca {
+	x := ir.NewAlloca(t)
+	fr.entry.Insts = append([]ir.Instruction{x}, fr.entry.Insts...)
+	return x
+}
+
+// 类型的零值
+func llZero(t llvmTypes.Type) constant.Constant {
+	switch t := t.(type) {
+	case *llvmTypes.IntType:
+		return constant.NewInt(t, 0)
+	case *llvmTypes.FloatType:
+		return constant.NewFloat(t, 0)
+	case *llvmTypes.PointerType:
+		return constant.NewNull(t)
+	}
+	return constant.NewZeroInitializer(t)
+}
+
+// 指针指向的元素类型
+func llElem(t types.Type) types.Type {
+	return t.Underlying().(*types.Pointer).Elem()
+}
+
+// 分配变量, SSA构造时已经完成逃逸分析, 逃逸到堆上的变量通过运行时分配, 其它变量分配在栈上
+// 有defer语句的函数中, 变量可能在panic恢复之后读取, 访问变量使用volatile避免被优化到寄存器中
+func (b *llBuilder) llAlloc(fr *llFrame, block *ir.Block, ins *ssa.Alloc) value.Value {
+	elem := llElem(ins.Type())
+	t := b.types.Type(elem)
+
+	var p value.Value
+	if ins.Heap {
+		size := constant.NewInt(b.types.Int, b.types.Sizeof(elem))
+		p = block.NewBitCast(b.llRuntime(block, "wa_alloc", llvmTypes.I8Ptr, size), llvmTypes.NewPointer(t))
+	} else {
+		// 每次执行Alloc指令都得到零值
+		p = b.llAlloca(fr, t)
+		block.NewStore(llZero(t), p).Volatile = fr.frame != nil
+	}
+
+	// 从恢复点返回之后通过副本读取地址
+	if fr.frame != nil {
+		slot := b.llAlloca(fr, p.Type())
+		block.NewStore(p, slot).Volatile = true
+		fr.slots[ins] = slot
+	}
+	return p
+}
+
+// 地址来自这些指令时一定不是空指针
+func llIsSafeAddr(v ssa.Value) bool {
+	switch v.(type) {
+	case *ssa.Alloc, *ssa.FieldAddr, *ssa.IndexAddr, *ssa.Global:
+		return true
+	}
+	return false
+}
+
+// 读取指针指向的值
+func (b *llBuilder) llLoad(fr *llFrame, block *ir.Block, addr ssa.Value, pos token.Pos) (value.Value, *ir.Block) {
+	p := b.llValue(fr, addr)
+	if !llIsSafeAddr(addr) {
+		block = b.llNilCheck(fr, block, p, pos)
+	}
+	x := block.NewLoad(b.types.Type(llElem(addr.Type())), p)
+	x.Volatile = fr.frame != nil
+	return x, block
+}
+
+// 写入指针指向的位置
+func (b *llBuilder) llStore(fr *llFrame, block *ir.Block, ins *ssa.Store) *ir.Block {
+	p := b.llValue(fr, ins.Addr)
+	if !llIsSafeAddr(ins.Addr) {
+		block = b.llNilCheck(fr, block, p, ins.Pos())
+	}
+	block.NewStore(b.llValue(fr, ins.Val), p).Volatile = fr.frame != nil
+	return block
+}
+
+// 结构体字段的地址
+func (b *llBuilder) llFieldAddr(fr *llFrame, block *ir.Block, ins *ssa.FieldAddr) (value.Value, *ir.Block) {
+	p := b.llValue(fr, ins.X)
+	if !llIsSafeAddr(ins.X) {
+		block = b.llNilCheck(fr, block, p, ins.Pos())
+	}
+	st := b.types.Type(llElem(ins.X.Type()))
+	x := block.NewGetElementPtr(st, p,
+		constant.NewInt(llvmTypes.I32, 0),
+		constant.NewInt(llvmTypes.I32, int64(ins.Field)),
+	)
+	return x, block
+}
+
+// 下标转换为机器字长的整数
+func (b *llBuilder) llIndexValue(fr *llFrame, block *ir.Block, v ssa.Value) value.Value {
+	t := v.Type().Underlying().(*types.Basic)
+	return b.llIntCast(block, b.llValue(fr, v), b.types.Int, t.Info()&types.IsUnsigned != 0)
+}
+
+// 数组指针或切片元素的地址
+func (b *llBuilder) llIndexAddr(fr *llFrame, block *ir.Block, ins *ssa.IndexAddr) (value.Value, *ir.Block) {
+	x := b.llValue(fr, ins.X)
+	i := b.llIndexValue(fr, block, ins.Index)
+
+	switch t := ins.X.Type().Underlying().(type) {
+	case *types.Pointer:
+		at := t.Elem().Underlying().(*types.Array)
+		if !llIsSafeAddr(ins.X) {
+			block = b.llNilCheck(fr, block, x, ins.Pos())
+		}
+		block = b.llBoundsCheck(fr, block, i, constant.NewInt(b.types.Int, at.Len()), ins.Pos())
+		return block.NewGetElementPtr(b.types.Type(t.Elem()), x, constant.NewInt(b.types.Int, 0), i), block
+
+	case *types.Slice:
+		block = b.llBoundsCheck(fr, block, i, block.NewExtractValue(x, 1), ins.Pos())
+		return block.NewGetElementPtr(b.types.Type(t.Elem()), block.NewExtractValue(x, 0), i), block
+	}
+	panic(fmt.Sprintf("unsupported IndexAddr: %v", ins))
+}
+
+// 数组或字符串的元素
+func (b *llBuilder) llIndex(fr *llFrame, block *ir.Block, xv, index ssa.Value, pos token.Pos) (value.Value, *ir.Block) {
+	x := b.llValue(fr, xv)
+
+	switch t := xv.Type().Underlying().(type) {
+	case *types.Array:
+		// 常量下标在类型检查时已经检查过范围
+		if c, ok := index.(*ssa.Const); ok {
+			return block.NewExtractValue(x, uint64(c.Int64())), block
+		}
+		i := b.llIndexValue(fr, block, index)
+		block = b.llBoundsCheck(fr, block, i, constant.NewInt(b.types.Int, t.Len()), pos)
+		tmp := b.llAlloca(fr, x.Type())
+		block.NewStore(x, tmp)
+		p := block.NewGetElementPtr(x.Type(), tmp, constant.NewInt(b.types.Int, 0), i)
+		return block.NewLoad(b.types.Type(t.Elem()), p), block
+
+	case *types.Basic:
+		if t.Info()&types.IsString != 0 {
+			i := b.llIndexValue(fr, block, index)
+			block = b.llBoundsCheck(fr, block, i, block.NewExtractValue(x, 1), pos)
+			p := block.NewGetElementPtr(llvmTypes.I8, block.NewExtractValue(x, 0), i)
+			return block.NewLoad(llvmTypes.I8, p), block
+		}
+	}
+	panic(fmt.Sprintf("unsupported index of %v", xv.Type()))
+}
+
+// 切片运算, X可以是切片/字符串/数组指针
+func (b *llBuilder) llSlice(fr *llFrame, block *ir.Block, ins *ssa.Slice) (value.Value, *ir.Block) {
+	x := b.llValue(fr, ins.X)
+
+	var ptr, length, capacity value.Value
+	kind := int64(llSlice2)
+	switch t := ins.X.Type().Underlying().(type) {
+	case *types.Slice:
+		ptr = block.NewExtractValue(x, 0)
+		length = block.NewExtractValue(x, 1)
+		capacity = block.NewExtractValue(x, 2)
+	case *types.Basic:
+		ptr = block.NewExtractValue(x, 0)
+		length = block.NewExtractValue(x, 1)
+		capacity = length
+		kind = llSliceString
+	case *types.Pointer:
+		at := t.Elem().Underlying().(*types.Array)
+		if !llIsSafeAddr(ins.X) {
+			block = b.llNilCheck(fr, block, x, ins.Pos())
+		}
+		ptr = block.NewGetElementPtr(b.types.Type(t.Elem()), x,
+			constant.NewInt(b.types.Int, 0),
+			constant.NewInt(b.types.Int, 0),
+		)
+		length = constant.NewInt(b.types.Int, at.Len())
+		capacity = length
+	default:
+		panic(fmt.Sprintf("unsupported slice of %v", ins.X.Type()))
+	}
+
+	var lo, hi, max value.Value = constant.NewInt(b.types.Int, 0), length, capacity
+	if ins.Low != nil {
+		lo = b.llIndexValue(fr, block, ins.Low)
+	}
+	if ins.High != nil {
+		hi = b.llIndexValue(fr, block, ins.High)
+	}
+	if ins.Max != nil {
+		max = b.llIndexValue(fr, block, ins.Max)
+		kind = llSlice3
+	}
+
+	// 0 <= lo <= hi <= max <= cap
+	fail := block.NewOr(
+		block.NewICmp(enum.IPredUGT, lo, hi),
+		block.NewOr(
+			block.NewICmp(enum.IPredUGT, hi, max),
+			block.NewICmp(enum.IPredUGT, max, capacity),
+		),
+	)
+	block = b.llCheck(fr, block, fail, "wa_panic_slice",
+		lo, hi, max, capacity, constant.NewInt(llvmTypes.I32, kind), b.llPos(ins.Pos()),
+	)
+
+	t := b.types.Type(ins.Type()).(*llvmTypes.StructType)
+	var result value.Value = constant.NewUndef(t)
+	result = block.NewInsertValue(result, block.NewGetElementPtr(t.Fields[0].(*llvmTypes.PointerType).ElemType, ptr, lo), 0)
+	result = block.NewInsertValue(result, block.NewSub(hi, lo), 1)
+	if kind != llSliceString {
+		result = block.NewInsertValue(result, block.NewSub(max, lo), 2)
+	}
+	return result, block
+}
+
+// make([]T, len, cap)
+func (b *llBuilder) llMakeSlice(fr *llFrame, block *ir.Block, ins *ssa.MakeSlice) value.Value {
+	t := b.types.Type(ins.Type())
+	elem := ins.Type().Underlying().(*types.Slice).Elem()
+
+	// 运行时的wa_slice和所有切片类型的内存布局相同
+	ret := b.llAlloca(fr, t)
+	b.llRuntime(block, "wa_makeslice", llvmTypes.Void,
+		b.llBytePtr(block, ret),
+		b.llIndexValue(fr, block, ins.Len),
+		b.llIndexValue(fr, block, ins.Cap),
+		constant.NewInt(b.types.Int, b.types.Sizeof(elem)),
+		b.llPos(ins.Pos()),
+	)
+	return block.NewLoad(t, ret)
+}
+
+// append(x, y...), y为切片或字符串
+func (b *llBuilder) llAppend(fr *llFrame, block *ir.Block, args []ssa.Value, typ types.Type) value.Value {
+	t := b.types.Type(typ)
+	elem := typ.Underlying().(*types.Slice).Elem()
+	x, y := b.llValue(fr, args[0]), b.llValue(fr, args[1])
+
+	ret := b.llAlloca(fr, t)
+	b.llRuntime(block, "wa_append", llvmTypes.Void,
+		b.llBytePtr(block, ret),
+		b.llBytePtr(block, block.NewExtractValue(x, 0)),
+		block.NewExtractValue(x, 1),
+		block.NewExtractValue(x, 2),
+		b.llBytePtr(block, block.NewExtractValue(y, 0)),
+		block.NewExtractValue(y, 1),
+		constant.NewInt(b.types.Int, b.types.Sizeof(elem)),
+	)
+	return block.NewLoad(t, ret)
+}
+
+// len(x)和cap(x)
+func (b *llBuilder) llLen(fr *llFrame, block *ir.Block, name string, arg ssa.Value) value.Value {
+	x := b.llValue(fr, arg)
+	switch t := arg.Type().Underlying().(type) {
+	case *types.Basic:
+		return block.NewExtractValue(x, 1)
+	case *types.Slice:
+		if name == "cap" {
+			return block.NewExtractValue(x, 2)
+		}
+		return block.NewExtractValue(x, 1)
+	case *types.Map:
+		return b.llRuntime(block, "wa_maplen", b.types.Int, x)
+	case *types.Pointer:
+		return constant.NewInt(b.types.Int, t.Elem().Underlying().(*types.Array).Len())
+	}
+	panic(fmt.Sprintf("unsupported %s of %v", name, arg.Type()))
+}
+
+// map键的比较方式, 和runtime.h中的定义一致
+const (
+	llKeyMem     = 0 // 按内存比较
+	llKeyString  = 1
+	llKeyFloat32 = 2
+	llKeyFloat64 = 3
+	llKeyFunc    = 4 // 使用生成的哈希函数和比较函数
+)
+
+// 整数/指针等没有填充字节的键按内存比较, 复数/结构体/数组/接口使用生成的函数
+func llMapKeyKind(t types.Type) int64 {
+	if t, ok := t.Underlying().(*types.Basic); ok {
+		switch {
+		case t.Info()&types.IsString != 0:
+			return llKeyString
+		case t.Kind() == types.Float32:
+			return llKeyFloat32
+		case t.Kind() == types.Float64:
+			return llKeyFloat64
+		case t.Info()&types.IsComplex != 0:
+			return llKeyFunc
+		}
+		return llKeyMem
+	}
+	if llIsDirect(t) {
+		return llKeyMem
+	}
+	return llKeyFunc
+}
+
+// make(map[K]V)
+func (b *llBuilder) llMakeMap(block *ir.Block, ins *ssa.MakeMap) value.Value {
+	mt := ins.Type().Underlying().(*types.Map)
+	kind := llMapKeyKind(mt.Key())
+	var hash, equal constant.Constant = constant.NewNull(llvmTypes.I8Ptr), constant.NewNull(llvmTypes.I8Ptr)
+	if kind == llKeyFunc {
+		hash = llBytePtrConst(b.llHashFunc(mt.Key()))
+		equal = llBytePtrConst(b.llEqualFunc(mt.Key()))
+	}
+	return b.llRuntime(block, "wa_makemap", llvmTypes.I8Ptr,
+		constant.NewInt(b.types.Int, b.types.Sizeof(mt.Key())),
+		constant.NewInt(b.types.Int, b.types.Sizeof(mt.Elem())),
+		constant.NewInt(llvmTypes.I32, kind),
+		hash,
+		equal,
+	)
+}
+
+// 可比较类型的哈希函数: hash(h, p, pos)将*p混合到哈希值h中
+// 用于map的键和接口中非直接保存的值, pos是动态类型不可哈希时panic的位置
+func (b *llBuilder) llHashFunc(t types.Type) *ir.Func {
+	if fn, ok := b.hashFuncs.At(t).(*ir.Func); ok {
+		return fn
+	}
+	lt := b.types.Type(t)
+	h := ir.NewParam("h", llvmTypes.I64)
+	p := ir.NewParam("p", llvmTypes.I8Ptr)
+	pos := ir.NewParam("pos", llvmTypes.I8Ptr)
+	fn := b.m.NewFunc("hash."+llTypeName(t), llvmTypes.I64, h, p, pos)
+	b.hashFuncs.Set(t, fn)
+
+	entry := fn.NewBlock("entry")
+	x := entry.NewLoad(lt, entry.NewBitCast(p, llvmTypes.NewPointer(lt)))
+	entry.NewRet(b.llHash(entry, t, h, x, pos))
+	return fn
+}
+
+// 依次混合值的各个部分, 和llEqual比较的部分一致
+func (b *llBuilder) llHash(block *ir.Block, t types.Type, h, x, pos value.Value) value.Value {
+	switch t := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsString != 0:
+			return b.llRuntime(block, "wa_hash_string", llvmTypes.I64, h,
+				block.NewExtractValue(x, 0), block.NewExtractValue(x, 1),
+			)
+		case t.Info()&types.IsFloat != 0:
+			return b.llHashFloat(block, h, x)
+		case t.Info()&types.IsComplex != 0:
+			h = b.llHashFloat(block, h, block.NewExtractValue(x, 0))
+			return b.llHashFloat(block, h, block.NewExtractValue(x, 1))
+		case t.Kind() == types.UnsafePointer:
+			return b.llRuntime(block, "wa_hash_int", llvmTypes.I64, h, block.NewPtrToInt(x, llvmTypes.I64))
+		}
+		if !x.Type().Equal(llvmTypes.I64) {
+			x = block.NewZExt(x, llvmTypes.I64)
+		}
+		return b.llRuntime(block, "wa_hash_int", llvmTypes.I64, h, x)
+
+	case *types.Pointer, *types.Chan:
+		return b.llRuntime(block, "wa_hash_int", llvmTypes.I64, h, block.NewPtrToInt(x, llvmTypes.I64))
+
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			h = b.llHash(block, t.Field(i).Type(), h, block.NewExtractValue(x, uint64(i)), pos)
+		}
+		return h
+
+	case *types.Array:
+		for i := int64(0); i < t.Len(); i++ {
+			h = b.llHash(block, t.Elem(), h, block.NewExtractValue(x, uint64(i)), pos)
+		}
+		return h
+
+	case *types.Interface:
+		return b.llRuntime(block, "wa_hash_iface", llvmTypes.I64, h,
+			block.NewExtractValue(x, 0), block.NewExtractValue(x, 1), pos,
+		)
+	}
+	panic(fmt.Sprintf("unsupported hash of %v", t))
+}
+
+// float32转换为float64后混合, 两者的值相同时哈希值相同
+func (b *llBuilder) llHashFloat(block *ir.Block, h, x value.Value) value.Value {
+	if x.Type().Equal(llvmTypes.Float) {
+		x = block.NewFPExt(x, llvmTypes.Double)
+	}
+	return b.llRuntime(block, "wa_hash_float", llvmTypes.I64, h, x)
+}
+
+// 运行时通过指针访问map的键
+func (b *llBuilder) llMapKey(fr *llFrame, block *ir.Block, key ssa.Value) value.Value {
+	k := b.llValue(fr, key)
+	p := b.llAlloca(fr, k.Type())
+	block.NewStore(k, p)
+	return b.llBytePtr(block, p)
+}
+
+// m[k] = v
+func (b *llBuilder) llMapUpdate(fr *llFrame, block *ir.Block, ins *ssa.MapUpdate) {
+	v := b.llValue(fr, ins.Value)
+	p := b.llRuntime(block, "wa_mapassign", llvmTypes.I8Ptr,
+		b.llValue(fr, ins.Map),
+		b.llMapKey(fr, block, ins.Key),
+		b.llPos(ins.Pos()),
+	)
+	block.NewStore(v, block.NewBitCast(p, llvmTypes.NewPointer(v.Type())))
+}
+
+// m[k]和v, ok := m[k], 键不存在时得到零值
+func (b *llBuilder) llMapLookup(fr *llFrame, block *ir.Block, ins *ssa.Lookup) value.Value {
+	vt := b.types.Type(ins.X.Type().Underlying().(*types.Map).Elem())
+	p := b.llRuntime(block, "wa_mapaccess", llvmTypes.I8Ptr,
+		b.llValue(fr, ins.X),
+		b.llMapKey(fr, block, ins.Index),
+		b.llPos(ins.Pos()),
+	)
+
+	zero := b.llAlloca(fr, vt)
+	block.NewStore(llZero(vt), zero)
+
+	found := block.NewICmp(enum.IPredNE, p, constant.NewNull(llvmTypes.I8Ptr))
+	v := block.NewLoad(vt, block.NewSelect(found, block.NewBitCast(p, zero.Type()), zero))
+	if !ins.CommaOk {
+		return v
+	}
+
+	var x value.Value = constant.NewUndef(b.types.Type(ins.Type()))
+	x = block.NewInsertValue(x, v, 0)
+	return block.NewInsertValue(x, found, 1)
+}
+
+// delete(m, k)
+func (b *llBuilder) llMapDelete(fr *llFrame, block *ir.Block, args []ssa.Value, pos token.Pos) {
+	b.llRuntime(block, "wa_mapdelete", llvmTypes.Void,
+		b.llValue(fr, args[0]),
+		b.llMapKey(fr, block, args[1]),
+		b.llPos(pos),
+	)
+}
+
+// for range m和for range s的迭代器
+func (b *llBuilder) llRange(fr *llFrame, block *ir.Block, ins *ssa.Range) value.Value {
+	x := b.llValue(fr, ins.X)
+	switch t := ins.X.Type().Underlying().(type) {
+	case *types.Map:
+		return b.llRuntime(block, "wa_mapiterinit", llvmTypes.I8Ptr, x)
+	case *types.Basic:
+		if t.Info()&types.IsString != 0 {
+			return b.llRuntime(block, "wa_stringiterinit", llvmTypes.I8Ptr,
+				block.NewExtractValue(x, 0), block.NewExtractValue(x, 1),
+			)
+		}
+	}
+	panic(fmt.Sprintf("unsupported range over %v", ins.X.Type()))
+}
+
+// 迭代器的下一个元素, 结果为(ok, k, v), 字符串的k和v为字节下标和字符
+// 没有使用的键或值的类型为types.Invalid, 用i8占位并且不从运行时读取
+func (b *llBuilder) llNext(fr *llFrame, block *ir.Block, ins *ssa.Next) value.Value {
+	next := "wa_mapiternext"
+	if ins.IsString {
+		next = "wa_stringiternext"
+	}
+	tuple := ins.Type().(*types.Tuple)
+
+	fields := []llvmTypes.Type{llvmTypes.I1}
+	ptrs := []value.Value{b.llValue(fr, ins.Iter)}
+	var allocas []*ir.InstAlloca
+	for i := 1; i < tuple.Len(); i++ {
+		t := tuple.At(i).Type()
+		if t == types.Typ[types.Invalid] {
+			fields = append(fields, llvmTypes.I8)
+			ptrs = append(ptrs, constant.NewNull(llvmTypes.I8Ptr))
+			allocas = append(allocas, nil)
+			continue
+		}
+		p := b.llAlloca(fr, b.types.Type(t))
+		fields = append(fields, p.ElemType)
+		ptrs = append(ptrs, b.llBytePtr(block, p))
+		allocas = append(allocas, p)
+	}
+
+	r := b.llRuntime(block, next, llvmTypes.I32, ptrs...)
+
+	var x value.Value = constant.NewUndef(llvmTypes.NewStruct(fields...))
+	x = block.NewInsertValue(x, block.NewICmp(enum.IPredNE, r, constant.NewInt(llvmTypes.I32, 0)), 0)
+	for i, p := range allocas {
+		if p != nil {
+			x = block.NewInsertValue(x, block.NewLoad(p.ElemType, p), uint64(i+1))
+		}
+	}
+	return x
+}
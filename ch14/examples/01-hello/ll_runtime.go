@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 调用runtime/runtime.c中的函数, 函数在第一次调用时根据参数类型声明
+// 运行时函数的指针参数统一使用i8*, 长度和下标使用机器字长的整数
+func (b *llBuilder) llRuntime(block *ir.Block, name string, ret llvmTypes.Type, args ...value.Value) *ir.InstCall {
+	fn, ok := b.runtime[name]
+	if !ok {
+		var params []*ir.Param
+		for _, arg := range args {
+			params = append(params, ir.NewParam("", arg.Type()))
+		}
+		fn = b.m.NewFunc(name, ret, params...)
+		b.runtime[name] = fn
+	}
+	return block.NewCall(fn, args...)
+}
+
+// 转换为i8*类型的指针
+func (b *llBuilder) llBytePtr(block *ir.Block, x value.Value) value.Value {
+	if x.Type().Equal(llvmTypes.I8Ptr) {
+		return x
+	}
+	return block.NewBitCast(x, llvmTypes.I8Ptr)
+}
+
+// 源码位置对应的字符串常量, 用于panic信息
+func (b *llBuilder) llPos(pos token.Pos) constant.Constant {
+	var s string
+	if pos.IsValid() {
+		s = b.fset.Position(pos).String()
+	}
+	return b.llCStringPtr(s)
+}
+
+// 条件fail成立时调用运行时的panic函数, 返回条件不成立时继续执行的块
+func (b *llBuilder) llCheck(fr *llFrame, block *ir.Block, fail value.Value, name string, args ...value.Value) *ir.Block {
+	failBlock := fr.fn.NewBlock("")
+	okBlock := fr.fn.NewBlock("")
+	block.NewCondBr(fail, failBlock, okBlock)
+
+	b.llRuntime(failBlock, name, llvmTypes.Void, args...)
+	failBlock.NewUnreachable()
+	return okBlock
+}
+
+// 空指针检查
+func (b *llBuilder) llNilCheck(fr *llFrame, block *ir.Block, x value.Value, pos token.Pos) *ir.Block {
+	fail := block.NewICmp(enum.IPredEQ, x, constant.NewNull(x.Type().(*llvmTypes.PointerType)))
+	return b.llCheck(fr, block, fail, "wa_panic_nil", b.llPos(pos))
+}
+
+// 下标检查, 负数的下标按无符号数比较时一定越界
+func (b *llBuilder) llBoundsCheck(fr *llFrame, block *ir.Block, i, n value.Value, pos token.Pos) *ir.Block {
+	fail := block.NewICmp(enum.IPredUGE, i, n)
+	return b.llCheck(fr, block, fail, "wa_panic_index", i, n, b.llPos(pos))
+}
+
+// 通过运行时库实现print/println, 输出格式和gc相同
+func (b *llBuilder) llPrint(fr *llFrame, block *ir.Block, ln bool, args ...ssa.Value) {
+	for i, arg := range args {
+		if i > 0 && ln {
+			b.llRuntime(block, "wa_print_space", llvmTypes.Void)
+		}
+
+		x := b.llValue(fr, arg)
+		switch t := arg.Type().Underlying().(type) {
+		case *types.Basic:
+			switch {
+			case t.Info()&types.IsBoolean != 0:
+				b.llRuntime(block, "wa_print_bool", llvmTypes.Void, block.NewZExt(x, llvmTypes.I8))
+			case t.Info()&types.IsInteger != 0:
+				if t.Info()&types.IsUnsigned != 0 {
+					b.llRuntime(block, "wa_print_uint", llvmTypes.Void, b.llIntCast(block, x, llvmTypes.I64, true))
+				} else {
+					b.llRuntime(block, "wa_print_int", llvmTypes.Void, b.llIntCast(block, x, llvmTypes.I64, false))
+				}
+			case t.Info()&types.IsFloat != 0:
+				b.llRuntime(block, "wa_print_float", llvmTypes.Void, b.llDouble(block, x))
+			case t.Info()&types.IsComplex != 0:
+				re := b.llDouble(block, block.NewExtractValue(x, 0))
+				im := b.llDouble(block, block.NewExtractValue(x, 1))
+				b.llRuntime(block, "wa_print_complex", llvmTypes.Void, re, im)
+			case t.Info()&types.IsString != 0:
+				b.llRuntime(block, "wa_print_string", llvmTypes.Void,
+					block.NewExtractValue(x, 0),
+					block.NewExtractValue(x, 1),
+				)
+			case t.Kind() == types.UnsafePointer:
+				b.llRuntime(block, "wa_print_pointer", llvmTypes.Void, x)
+			default:
+				panic(fmt.Sprintf("unsupported print argument: %v", arg))
+			}
+
+		case *types.Pointer, *types.Map, *types.Chan, *types.Signature:
+			b.llRuntime(block, "wa_print_pointer", llvmTypes.Void, b.llBytePtr(block, x))
+
+		case *types.Slice:
+			b.llRuntime(block, "wa_print_slice", llvmTypes.Void,
+				b.llBytePtr(block, block.NewExtractValue(x, 0)),
+				block.NewExtractValue(x, 1),
+				block.NewExtractValue(x, 2),
+			)
+
+		case *types.Interface:
+			b.llRuntime(block, "wa_print_eface", llvmTypes.Void,
+				block.NewExtractValue(x, 0),
+				block.NewExtractValue(x, 1),
+			)
+
+		default:
+			panic(fmt.Sprintf("unsupported print argument: %v", arg))
+		}
+	}
+	if ln {
+		b.llRuntime(block, "wa_print_nl", llvmTypes.Void)
+	}
+}
+
+// 转换为double类型
+func (b *llBuilder) llDouble(block *ir.Block, x value.Value) value.Value {
+	if x.Type().Equal(llvmTypes.Float) {
+		return block.NewFPExt(x, llvmTypes.Double)
+	}
+	return x
+}
+
+// 整数的位宽转换, 扩展时根据unsigned选择零扩展或符号扩展
+func (b *llBuilder) llIntCast(block *ir.Block, x value.Value, to *llvmTypes.IntType, unsigned bool) value.Value {
+	from := x.Type().(*llvmTypes.IntType)
+	switch {
+	case from.BitSize > to.BitSize:
+		return block.NewTrunc(x, to)
+	case from.BitSize < to.BitSize && unsigned:
+		return block.NewZExt(x, to)
+	case from.BitSize < to.BitSize:
+		return block.NewSExt(x, to)
+	}
+	return x
+}
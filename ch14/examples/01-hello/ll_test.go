@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"llvmdriver"
+	"ssago/lltypes"
+)
+
+// 复数运算和字符串的for range
+func TestComplexAndStringRange(t *testing.T) {
+	const src = `package main
+
+func main() {
+	a, b := complex(1.5, 2), complex(-3, 0.5)
+	for _, z := range []complex128{a + b, a - b, a * b, a / b * 37} {
+		println(int(real(z)*4), int(imag(z)*4))
+	}
+	var c, d complex64 = complex(1, 2), complex(3, -4)
+	z := c * d
+	println(int(real(z)), int(imag(z)), c/d == complex(-0.2, 0.4), a != b)
+	var zero complex128
+	inf := real(1 / zero)
+	println(inf > 0, real(a/complex(inf, inf)) == 0)
+
+	for i, r := range "h\u00e9, \u4e16\xff!" {
+		println(i, r)
+	}
+	n := 0
+	for range "abc\xe4\xb8" {
+		n++
+	}
+	println(n)
+}
+`
+	const want = `-6 10
+18 6
+-22 -21
+-56 -108
+11 2 true true
+true true
+0 104
+1 233
+3 44
+4 32
+5 19990
+8 65533
+9 33
+5
+`
+	if got := runNative(t, src); got != want {
+		t.Errorf("输出:\n%s期望:\n%s", got, want)
+	}
+}
+
+// panic的信息和退出码与gc编译的程序相同, 只比较到goroutine那一行, 之后的调用栈不同.
+// 运行时库的print输出到标准输出, gc输出到标准错误, 所以比较两者合并后的输出
+func TestPanicRecover(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "recover",
+			src: `package main
+
+type E struct{ code int }
+
+func (e *E) Error() string { return "E" }
+
+func f(n int) (r int) {
+	defer func() {
+		if x := recover(); x != nil {
+			println("recovered:", x.(string))
+			r = -n
+		}
+	}()
+	defer println("defer", n)
+	if n > 1 {
+		panic("too big")
+	}
+	return n * 10
+}
+
+func g() (err error) {
+	defer func() {
+		err = recover().(error)
+	}()
+	var a []int
+	_ = a[3]
+	return nil
+}
+
+func h() {
+	defer func() {
+		println(recover().(*E).code)
+	}()
+	panic(&E{7})
+}
+
+func main() {
+	println(f(1), f(2))
+	println(g().Error())
+	h()
+	println(recover() == nil)
+}
+`,
+		},
+		{
+			// 没有被延迟调用直接调用的函数中recover返回nil
+			name: "indirect",
+			src: `package main
+
+func try() {
+	println(recover() == nil)
+}
+
+func main() {
+	defer func() {
+		try()
+	}()
+	panic("indirect")
+}
+`,
+		},
+		{
+			name: "repanic",
+			src: `package main
+
+type code int
+
+func main() {
+	defer func() {
+		panic(code(3))
+	}()
+	defer func() {
+		println("first:", recover().(string))
+		panic("second")
+	}()
+	panic("first")
+}
+`,
+		},
+		{
+			name: "repanic-same",
+			src: `package main
+
+func main() {
+	defer func() {
+		panic(recover())
+	}()
+	panic("same")
+}
+`,
+		},
+		{
+			name: "runtime-error",
+			src: `package main
+
+func main() {
+	defer println("deferred")
+	var m map[string]int
+	println(len(m))
+	m["a"] = 1
+}
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exe := buildNative(t, tt.src)
+			got := runCommand(t, exec.Command(exe))
+
+			dir := t.TempDir()
+			src := filepath.Join(dir, "main.go")
+			if err := os.WriteFile(src, []byte(tt.src), 0666); err != nil {
+				t.Fatal(err)
+			}
+			gcExe := filepath.Join(dir, "main")
+			if out, err := exec.Command("go", "build", "-o", gcExe, src).CombinedOutput(); err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			want := runCommand(t, exec.Command(gcExe))
+			if got != want {
+				t.Errorf("输出:\n%s期望:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// 运行程序, 返回合并的标准输出和标准错误以及退出码, goroutine那一行之后的内容被去掉
+func runCommand(t *testing.T, cmd *exec.Cmd) string {
+	t.Helper()
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	code := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		code = exitErr.ExitCode()
+	}
+	s := out.String()
+	if i := strings.Index(s, "goroutine 1 [running]:\n"); i >= 0 {
+		s = s[:i+len("goroutine 1 [running]:\n")]
+	}
+	return fmt.Sprintf("%sexit %d\n", s, code)
+}
+
+// 用LLVM工具链编译程序并链接运行时库后运行, 返回标准输出
+func runNative(t *testing.T, src string) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	cmd := exec.Command(buildNative(t, src))
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String()
+}
+
+// 用LLVM工具链编译程序并链接运行时库, 返回可执行程序的路径; 没有clang, 也没有llc时跳过
+func buildNative(t *testing.T, src string) string {
+	t.Helper()
+	_, errClang := exec.LookPath("clang")
+	_, errLLC := exec.LookPath("llc")
+	if errClang != nil && errLLC != nil {
+		t.Skip("没有找到LLVM工具链")
+	}
+	target, err := lltypes.LookupTarget(runtime.GOARCH)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ssaPkg, _, err := buildProgram(token.NewFileSet(), "test.go", src, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	llPath := filepath.Join(dir, "a.ll")
+	if err := os.WriteFile(llPath, []byte(llModule(ssaPkg.Prog, ssaPkg, target).String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+	build := llvmdriver.NewConfig(flag.NewFlagSet("ssago", flag.ContinueOnError), "exe")
+	build.Inputs = []string{"runtime/runtime.c"}
+	exe, err := build.Build(llPath, filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exe
+}
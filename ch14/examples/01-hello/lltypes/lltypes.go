@@ -0,0 +1,249 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+// Go类型到LLVM类型的映射
+//
+// 内存布局和目标平台的types.Sizes保持一致, 类型检查时使用同一个Sizes,
+// 这样unsafe.Sizeof等计算的结果和生成的代码相同:
+//
+//	bool              i1
+//	int/uint/uintptr  机器字长的整数
+//	string            {i8*, int}
+//	unsafe.Pointer    i8*
+//	*T                T*
+//	[N]T              [N x T]
+//	struct            {字段...}, 命名的结构体类型对应LLVM的命名类型
+//	[]T               {T*, int, int}
+//	map/chan          i8*, 由运行时库实现
+//	interface         {i8*, i8*}, 类型信息和数据指针
+//	func              i8*, 指向闭包对象
+package lltypes
+
+import (
+	"fmt"
+	"go/types"
+	"slices"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// 目标平台
+type Target struct {
+	Triple     string      // 目标三元组
+	DataLayout string      // LLVM的数据布局
+	Sizes      types.Sizes // Go类型的大小和对齐, 和gc一样结构体的大小按对齐补齐, 与LLVM的布局一致
+}
+
+var (
+	// x86-64 Linux
+	X86_64Linux = &Target{
+		Triple:     "x86_64-unknown-linux-gnu",
+		DataLayout: "e-m:e-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+		Sizes:      types.SizesFor("gc", "amd64"),
+	}
+
+	// aarch64 Linux
+	AArch64Linux = &Target{
+		Triple:     "aarch64-unknown-linux-gnu",
+		DataLayout: "e-m:e-i8:8:32-i16:16:32-i64:64-i128:128-n32:64-S128",
+		Sizes:      types.SizesFor("gc", "arm64"),
+	}
+)
+
+// 根据目标三元组或GOARCH查找目标平台
+//
+// 三元组按体系结构匹配, 厂商和环境可以省略或不同,
+// 比如aarch64-linux-gnu和aarch64-unknown-linux-gnu都对应AArch64Linux; 操作系统只支持Linux
+func LookupTarget(name string) (*Target, error) {
+	arch, rest, isTriple := strings.Cut(name, "-")
+	if isTriple && !slices.Contains(strings.Split(rest, "-"), "linux") {
+		return nil, fmt.Errorf("lltypes: unsupported target %q", name)
+	}
+	switch arch {
+	case "amd64", "x86_64":
+		return X86_64Linux, nil
+	case "arm64", "aarch64":
+		return AArch64Linux, nil
+	}
+	return nil, fmt.Errorf("lltypes: unsupported target %q", name)
+}
+
+// 类型映射, 命名的结构体类型会注册到LLVM模块中
+type Mapper struct {
+	target *Target
+	module *ir.Module
+	cache  typeutil.Map
+
+	// 机器字长的整数
+	Int *llvmTypes.IntType
+}
+
+func NewMapper(target *Target, m *ir.Module) *Mapper {
+	p := &Mapper{
+		target: target,
+		module: m,
+		Int:    llInt(target.Sizes.Sizeof(types.Typ[types.Int]) * 8),
+	}
+	m.TargetTriple = target.Triple
+	m.DataLayout = target.DataLayout
+	return p
+}
+
+// 目标平台
+func (p *Mapper) Target() *Target {
+	return p.target
+}
+
+// 字符串类型
+func (p *Mapper) StringType() *llvmTypes.StructType {
+	return p.Type(types.Typ[types.String]).(*llvmTypes.StructType)
+}
+
+// Go类型对应的LLVM类型
+func (p *Mapper) Type(t types.Type) llvmTypes.Type {
+	if x, ok := p.cache.At(t).(llvmTypes.Type); ok {
+		return x
+	}
+
+	// 命名的结构体可能递归引用自身, 先注册再填充字段
+	if named, ok := t.(*types.Named); ok {
+		if st, ok := named.Underlying().(*types.Struct); ok {
+			x := &llvmTypes.StructType{}
+			p.module.NewTypeDef(p.typeName(named), x)
+			p.cache.Set(t, x)
+			x.Fields = p.fields(st)
+			return x
+		}
+	}
+
+	x := p.lower(t)
+	p.cache.Set(t, x)
+	return x
+}
+
+func (p *Mapper) lower(t types.Type) llvmTypes.Type {
+	switch t := t.(type) {
+	case *types.Basic:
+		return p.basic(t)
+	case *types.Named:
+		return p.Type(t.Underlying())
+	case *types.Alias:
+		return p.Type(types.Unalias(t))
+	case *types.Pointer:
+		return llvmTypes.NewPointer(p.Type(t.Elem()))
+	case *types.Array:
+		return llvmTypes.NewArray(uint64(t.Len()), p.Type(t.Elem()))
+	case *types.Struct:
+		return llvmTypes.NewStruct(p.fields(t)...)
+	case *types.Slice:
+		return llvmTypes.NewStruct(llvmTypes.NewPointer(p.Type(t.Elem())), p.Int, p.Int)
+	case *types.Map, *types.Chan, *types.Signature:
+		return llvmTypes.I8Ptr
+	case *types.Interface:
+		return llvmTypes.NewStruct(llvmTypes.I8Ptr, llvmTypes.I8Ptr)
+	case *types.Tuple:
+		var fields []llvmTypes.Type
+		for i := 0; i < t.Len(); i++ {
+			fields = append(fields, p.Type(t.At(i).Type()))
+		}
+		return llvmTypes.NewStruct(fields...)
+	}
+	panic(fmt.Sprintf("lltypes: unsupported type %v", t))
+}
+
+func (p *Mapper) basic(t *types.Basic) llvmTypes.Type {
+	switch t.Kind() {
+	case types.Bool, types.UntypedBool:
+		return llvmTypes.I1
+	case types.Int8, types.Uint8:
+		return llvmTypes.I8
+	case types.Int16, types.Uint16:
+		return llvmTypes.I16
+	case types.Int32, types.Uint32, types.UntypedRune:
+		return llvmTypes.I32
+	case types.Int64, types.Uint64:
+		return llvmTypes.I64
+	case types.Int, types.Uint, types.Uintptr, types.UntypedInt:
+		return p.Int
+	case types.Float32:
+		return llvmTypes.Float
+	case types.Float64, types.UntypedFloat:
+		return llvmTypes.Double
+	case types.Complex64:
+		return llvmTypes.NewStruct(llvmTypes.Float, llvmTypes.Float)
+	case types.Complex128, types.UntypedComplex:
+		return llvmTypes.NewStruct(llvmTypes.Double, llvmTypes.Double)
+	case types.String, types.UntypedString:
+		return llvmTypes.NewStruct(llvmTypes.I8Ptr, p.Int)
+	case types.UnsafePointer, types.UntypedNil:
+		return llvmTypes.I8Ptr
+	}
+	panic(fmt.Sprintf("lltypes: unsupported basic type %v", t))
+}
+
+func (p *Mapper) fields(t *types.Struct) []llvmTypes.Type {
+	n := t.NumFields()
+	fields := make([]llvmTypes.Type, n)
+	for i := range fields {
+		fields[i] = p.Type(t.Field(i).Type())
+	}
+	// 和gc一样, 非空结构体的最后一个字段大小为0时补一个字节, 避免指向它的指针越过结构体
+	if n > 0 && p.Sizeof(t.Field(n-1).Type()) == 0 && p.Sizeof(t) > 0 {
+		fields = append(fields, llvmTypes.I8)
+	}
+	return fields
+}
+
+func (p *Mapper) typeName(t *types.Named) string {
+	return types.TypeString(t, func(pkg *types.Package) string { return pkg.Name() })
+}
+
+// 函数签名对应的LLVM函数类型
+// 没有返回值对应void, 多个返回值打包为结构体
+func (p *Mapper) FuncType(sig *types.Signature) *llvmTypes.FuncType {
+	var params []llvmTypes.Type
+	if recv := sig.Recv(); recv != nil {
+		params = append(params, p.Type(recv.Type()))
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, p.Type(sig.Params().At(i).Type()))
+	}
+	return llvmTypes.NewFunc(p.ResultType(sig.Results()), params...)
+}
+
+// 函数返回值的类型
+func (p *Mapper) ResultType(results *types.Tuple) llvmTypes.Type {
+	switch results.Len() {
+	case 0:
+		return llvmTypes.Void
+	case 1:
+		return p.Type(results.At(0).Type())
+	}
+	return p.Type(results)
+}
+
+// 类型的大小, 和类型检查使用的Sizes一致
+func (p *Mapper) Sizeof(t types.Type) int64 {
+	return p.target.Sizes.Sizeof(t)
+}
+
+// 类型的对齐, 和类型检查使用的Sizes一致
+func (p *Mapper) Alignof(t types.Type) int64 {
+	return p.target.Sizes.Alignof(t)
+}
+
+func llInt(bits int64) *llvmTypes.IntType {
+	switch bits {
+	case 8:
+		return llvmTypes.I8
+	case 16:
+		return llvmTypes.I16
+	case 32:
+		return llvmTypes.I32
+	case 64:
+		return llvmTypes.I64
+	}
+	return llvmTypes.NewInt(uint64(bits))
+}
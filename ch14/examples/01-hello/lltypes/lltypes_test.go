@@ -0,0 +1,28 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+package lltypes
+
+import "testing"
+
+func TestLookupTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want *Target
+	}{
+		{"amd64", X86_64Linux},
+		{"x86_64", X86_64Linux},
+		{"x86_64-unknown-linux-gnu", X86_64Linux},
+		{"x86_64-pc-linux-gnu", X86_64Linux},
+		{"arm64", AArch64Linux},
+		{"aarch64-linux-gnu", AArch64Linux},
+		{"aarch64-unknown-linux-gnu", AArch64Linux},
+		{"aarch64-apple-darwin", nil},
+		{"riscv64-linux-gnu", nil},
+		{"wasm", nil},
+	} {
+		got, err := LookupTarget(tt.name)
+		if got != tt.want || (err == nil) != (tt.want != nil) {
+			t.Errorf("LookupTarget(%q) = %v, %v", tt.name, got, err)
+		}
+	}
+}
@@ -1,32 +1,297 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"go/types"
 	"log"
 	"os"
+	"runtime"
 
 	"golang.org/x/tools/go/ssa"
+
+	"llvmdriver"
+	"ssago/lltypes"
 )
 
 const src = `
 package main
 
+type Point struct {
+	X, Y int
+}
+
+var s = "hello ssa"
+
+var (
+	counter int
+	squares [5]int
+	origin  = &Point{1, 2}
+)
+
+func init() {
+	for i := 0; i < len(squares); i++ {
+		squares[i] = i * i
+	}
+	counter = 100
+}
+
+func init() {
+	counter++
+}
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func divmod(a, b int) (int, int) {
+	return a / b, a % b
+}
+
+func greet(name string) {
+	print("Hello，", name, "！\n")
+}
+
+type Shape interface {
+	Area() int
+	Name() string
+}
+
+type Rect struct {
+	W, H int
+}
+
+func (r Rect) Area() int    { return r.W * r.H }
+func (r Rect) Name() string { return "rect" }
+
+type Square struct {
+	Side int
+}
+
+func (s *Square) Area() int    { return s.Side * s.Side }
+func (s *Square) Name() string { return "square" }
+
+type MyError struct {
+	Code int
+}
+
+func (e *MyError) Error() string { return "my error" }
+
+func check(n int) error {
+	if n < 0 {
+		return &MyError{n}
+	}
+	return nil
+}
+
+func describe(x interface{}) string {
+	switch v := x.(type) {
+	case nil:
+		return "nil"
+	case int:
+		if v > 100 {
+			return "big int"
+		}
+		return "int"
+	case string:
+		return "string " + v
+	case Shape:
+		return "shape " + v.Name()
+	}
+	return "other"
+}
+
+func makeCounter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func apply(f func(int) int, x int) int {
+	return f(x)
+}
+
+func double(x int) int {
+	return x * 2
+}
+
+func safeDiv(a, b int) (q int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	return a / b, nil
+}
+
+func deferOrder() {
+	for i := 0; i < 3; i++ {
+		defer println("defer", i)
+	}
+}
+
+func sum(xs []int) int {
+	s := 0
+	for i := 0; i < len(xs); i++ {
+		s += xs[i]
+	}
+	return s
+}
+
 func main() {
-	println("Hello，凹语言！")
+	for i := 0; i < 3; i++ {
+		println(s)
+	}
+	println(counter, squares[4], origin.X, origin.Y)
+
+	greet("凹语言")
 	println("The answer is:", 42)
+
+	for i := 0; i < 3; i++ {
+		println(i, fib(i+10), i<<62 != 0)
+	}
+
+	q, r := divmod(17, 5)
+	println(q, r)
+
+	s := "hello"
+	s = s + ", " + "world"
+	println(s, len(s), s < "hi", s[1:4])
+	println(3.5, float32(-0.25), uint8(200))
+
+	var xs []int
+	for i := 1; i <= 10; i++ {
+		xs = append(xs, i*i)
+	}
+	println(len(xs), cap(xs), sum(xs), sum(xs[2:5]))
+
+	var pt Point
+	pt.X = 3
+	pt.Y = 4
+	p := &pt
+	p.X += 10
+	println(pt.X, pt.Y)
+
+	m := make(map[string]int)
+	m["one"] = 1
+	m["two"] = 2
+	m["three"] = 3
+	delete(m, "two")
+	v, ok := m["two"]
+	total := 0
+	for _, n := range m {
+		total += n
+	}
+	println(len(m), m["one"], v, ok, total)
+
+	println(xs[len(xs)-1+q-3])
+
+	// *Rect通过值接收者的方法实现Shape
+	shapes := []Shape{Rect{3, 4}, &Square{5}, &Rect{1, 2}}
+	area := 0
+	for i := 0; i < len(shapes); i++ {
+		area += shapes[i].Area()
+		println(shapes[i].Name())
+	}
+	println(area)
+
+	if err := check(-1); err != nil {
+		println(err.Error(), err.(*MyError).Code)
+	}
+	println(check(1) == nil)
+
+	var e interface{} = shapes[1]
+	println(describe(42), describe(1000), describe("go"), describe(e), describe(nil), describe(1.5))
+	if sh, ok := e.(Shape); ok {
+		println(sh.Area())
+	}
+	var x interface{} = Rect{1, 2}
+	println(x == Rect{1, 2}, x == Rect{2, 1}, x == e)
+
+	next := makeCounter()
+	next()
+	next()
+	println(next(), apply(double, 21), apply(func(x int) int { return x + area }, 1))
+	name, areaOf := shapes[0].Name, Rect.Area
+	println(name(), areaOf(Rect{2, 5}))
+
+	// 大量分配的临时对象由垃圾回收器释放
+	var keep []*Point
+	for i := 0; i < 1000000; i++ {
+		p := &Point{i, i}
+		if i%100000 == 0 {
+			keep = append(keep, p)
+		}
+	}
+	println(len(keep), keep[9].X, keep[9].Y)
+
+	deferOrder()
+	q, err := safeDiv(7, 2)
+	println(q, err == nil)
+	q, err = safeDiv(1, 0)
+	println(q, err.Error())
 }
 `
 
 func main() {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, "test.go", src, parser.AllErrors)
+	// 默认只生成_a.ll, 其它产物链接运行时库runtime/runtime.c
+	build := llvmdriver.NewConfig(flag.CommandLine, "ll")
+	build.Inputs = []string{"runtime/runtime.c"}
+	flag.Parse()
+
+	// 源码写入文件, 调试器根据调试信息中的路径查找源码
+	if err := os.WriteFile("_a.go", []byte(src), 0666); err != nil {
+		log.Fatal(err)
+	}
+
+	// 类型检查和代码生成使用同一个目标平台的Sizes
+	targetName := runtime.GOARCH
+	if build.Target != "" {
+		targetName = build.Target
+	}
+	target, err := lltypes.LookupTarget(targetName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	ssaPkg, _, err := buildProgram(token.NewFileSet(), "_a.go", src, target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ssaProg := ssaPkg.Prog
+
+	ssaPkg.Func("main").WriteTo(os.Stdout)
+
+	runFunc(ssaPkg.Func("main"))
+
+	// 生成LLVM-IR, 由LLVM工具链编译并链接运行时库
+	if err := os.WriteFile("_a.ll", []byte(llModule(ssaProg, ssaPkg, target).String()), 0666); err != nil {
+		log.Fatal(err)
+	}
+	if build.Target != "" {
+		build.Target = target.Triple
+	}
+	if _, err := build.Build("_a.ll", "_a"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 解析和类型检查源码并构建SSA, 类型检查使用目标平台的Sizes
+// 程序可以导入unsafe包, 不支持导入其它包
+func buildProgram(fset *token.FileSet, filename, src string, target *lltypes.Target) (*ssa.Package, *types.Info, error) {
+	f, err := parser.ParseFile(fset, filename, src, parser.AllErrors)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	info := &types.Info{
 		Types:      make(map[ast.Expr]types.TypeAndValue),
 		Defs:       make(map[*ast.Ident]types.Object),
@@ -34,19 +299,32 @@ func main() {
 		Implicits:  make(map[ast.Node]types.Object),
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
 	}
 
-	conf := types.Config{Importer: nil}
+	conf := types.Config{Importer: unsafeImporter{}, Sizes: target.Sizes}
 	pkg, err := conf.Check("test.go", fset, []*ast.File{f}, info)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions)
+	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics|ssa.GlobalDebug)
+	for _, imp := range pkg.Imports() {
+		ssaProg.CreatePackage(imp, nil, nil, true)
+	}
 	var ssaPkg = ssaProg.CreatePackage(pkg, []*ast.File{f}, info, true)
 
-	ssaPkg.Build()
-	ssaPkg.Func("main").WriteTo(os.Stdout)
+	// main包的init函数调用导入的包的init函数, 导入的包也需要构建
+	ssaProg.Build()
+	return ssaPkg, info, nil
+}
 
-	runFunc(ssaPkg.Func("main"))
+// 只能导入unsafe包, unsafe中只有内置的类型和函数, 没有需要生成代码的成员
+type unsafeImporter struct{}
+
+func (unsafeImporter) Import(path string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	return nil, fmt.Errorf("不支持导入包%q", path)
 }
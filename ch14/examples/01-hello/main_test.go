@@ -0,0 +1,164 @@
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+	llvmTypes "github.com/llir/llvm/ir/types"
+
+	"ssago/lltypes"
+)
+
+const unsafeSrc = `package main
+
+import "unsafe"
+
+type T struct {
+	a bool
+	b int64
+	c int16
+	d string
+	e []byte
+	f any
+	g [3]int32
+	h *T
+	i float32
+	j complex128
+	k uint8
+	l map[int]int
+	m func()
+	n struct {
+		x int8
+		y complex64
+	}
+}
+
+// 最后一个字段的大小为0
+type E struct {
+	a int64
+	z struct{}
+}
+
+var t T
+var e E
+
+func main() {
+	println(unsafe.Sizeof(t), unsafe.Alignof(t))
+	println(unsafe.Sizeof(t.d), unsafe.Sizeof(t.f), unsafe.Sizeof(t.n), unsafe.Alignof(t.n))
+	println(unsafe.Offsetof(t.a), unsafe.Offsetof(t.b), unsafe.Offsetof(t.c), unsafe.Offsetof(t.d))
+	println(unsafe.Offsetof(t.e), unsafe.Offsetof(t.f), unsafe.Offsetof(t.g), unsafe.Offsetof(t.h))
+	println(unsafe.Offsetof(t.i), unsafe.Offsetof(t.j), unsafe.Offsetof(t.k), unsafe.Offsetof(t.l))
+	println(unsafe.Offsetof(t.m), unsafe.Offsetof(t.n), unsafe.Offsetof(t.n.y))
+	println(unsafe.Sizeof(e), unsafe.Offsetof(e.z))
+
+	p := unsafe.Pointer(&t.b)
+	println(*(*int64)(p))
+}
+`
+
+// unsafe.Sizeof/Alignof/Offsetof的结果和LLVM按数据布局计算的结果一致
+func TestUnsafeLayout(t *testing.T) {
+	for _, target := range []*lltypes.Target{lltypes.X86_64Linux, lltypes.AArch64Linux} {
+		t.Run(target.Triple, func(t *testing.T) {
+			ssaPkg, info, err := buildProgram(token.NewFileSet(), "unsafe.go", unsafeSrc, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := llModule(ssaPkg.Prog, ssaPkg, target)
+			mapper := lltypes.NewMapper(target, ir.NewModule())
+
+			n := 0
+			for expr, tv := range info.Types {
+				call, ok := expr.(*ast.CallExpr)
+				if !ok || tv.Value == nil {
+					continue
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok {
+					continue
+				}
+				got, _ := constant.Int64Val(tv.Value)
+				arg := info.TypeOf(call.Args[0])
+
+				var want int64
+				switch sel.Sel.Name {
+				case "Sizeof":
+					want, _ = llLayout(mapper.Type(arg))
+				case "Alignof":
+					_, want = llLayout(mapper.Type(arg))
+				case "Offsetof":
+					x := call.Args[0].(*ast.SelectorExpr)
+					index := info.Selections[x].Index()
+					st := mapper.Type(info.TypeOf(x.X)).(*llvmTypes.StructType)
+					want = llOffsetof(st, index[len(index)-1])
+				default:
+					continue
+				}
+				n++
+				if got != want {
+					t.Errorf("unsafe.%s(%s) = %d, LLVM: %d", sel.Sel.Name, types.ExprString(call.Args[0]), got, want)
+				}
+			}
+			if n != 23 {
+				t.Errorf("检查了%d个调用, 期望23个", n)
+			}
+			if m.TargetTriple != target.Triple {
+				t.Errorf("目标三元组: %s", m.TargetTriple)
+			}
+			for _, f := range m.Funcs {
+				if f.Name() == "unsafe.init" && len(f.Blocks) == 0 {
+					t.Errorf("unsafe.init没有函数体")
+				}
+			}
+		})
+	}
+}
+
+// LLVM类型在64位目标平台上的大小和ABI对齐, 两个目标平台的数据布局中基本类型都按自身大小对齐
+func llLayout(t llvmTypes.Type) (size, align int64) {
+	switch t := t.(type) {
+	case *llvmTypes.IntType:
+		size = (int64(t.BitSize) + 7) / 8
+		return size, size
+	case *llvmTypes.FloatType:
+		switch t.Kind {
+		case llvmTypes.FloatKindFloat:
+			return 4, 4
+		case llvmTypes.FloatKindDouble:
+			return 8, 8
+		}
+	case *llvmTypes.PointerType:
+		return 8, 8
+	case *llvmTypes.ArrayType:
+		size, align = llLayout(t.ElemType)
+		return size * int64(t.Len), align
+	case *llvmTypes.StructType:
+		align = 1
+		for _, f := range t.Fields {
+			fsize, falign := llLayout(f)
+			size = (size + falign - 1) / falign * falign
+			size += fsize
+			align = max(align, falign)
+		}
+		return (size + align - 1) / align * align, align
+	}
+	panic("llLayout: unsupported type " + t.String())
+}
+
+// 结构体第i个字段的偏移
+func llOffsetof(t *llvmTypes.StructType, i int) int64 {
+	var offset int64
+	for j, f := range t.Fields {
+		size, align := llLayout(f)
+		offset = (offset + align - 1) / align * align
+		if j == i {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
@@ -0,0 +1,1166 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+#include "runtime.h"
+
+#include <math.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// -----------------------------------------------------------------------------
+// 运行时错误
+
+// 运行时错误的类型, 值为错误信息
+static wa_string wa_error_Error(void* data) {
+	return *(wa_string*)data;
+}
+
+static int32_t wa_error_equal(const void* x, const void* y) {
+	const wa_string* a = (const wa_string*)x;
+	const wa_string* b = (const wa_string*)y;
+	return wa_string_compare(a->ptr, a->len, b->ptr, b->len) == 0;
+}
+
+static uint64_t wa_error_hash(uint64_t h, const void* p, const char* pos) {
+	const wa_string* s = (const wa_string*)p;
+	return wa_hash_string(h, s->ptr, s->len);
+}
+
+static const wa_method wa_error_methods[] = {
+	{"Error", (void*)wa_error_Error},
+};
+
+static const wa_type wa_error_type = {
+	"runtime.Error", sizeof(wa_string), WA_TYPE_COMPARABLE, WA_KIND_OTHER,
+	wa_error_equal, wa_error_hash, 1, wa_error_methods,
+};
+
+// 运行时错误作为interface{}值的itab
+static const struct {
+	const wa_type* type;
+} wa_error_itab = {&wa_error_type};
+
+// 以运行时错误的值panic, 可以被recover
+static void wa_throw(const char* prefix, const char* msg, const char* pos) {
+	size_t n = strlen(prefix) + strlen(msg);
+	char* p = (char*)wa_alloc(n + 1);
+	strcpy(p, prefix);
+	strcat(p, msg);
+
+	wa_string* err = (wa_string*)wa_alloc(sizeof(wa_string));
+	err->ptr = (const uint8_t*)p;
+	err->len = n;
+	wa_panic((const wa_itab*)&wa_error_itab, err, pos);
+}
+
+static void wa_runtime_error(const char* msg, const char* pos) {
+	wa_throw("runtime error: ", msg, pos);
+}
+
+void wa_panic_index(intptr_t i, intptr_t len, const char* pos) {
+	char buf[128];
+	snprintf(buf, sizeof(buf), "index out of range [%ld] with length %ld", (long)i, (long)len);
+	wa_runtime_error(buf, pos);
+}
+
+void wa_panic_slice(intptr_t lo, intptr_t hi, intptr_t max, intptr_t cap, int kind, const char* pos) {
+	char buf[128];
+	const char* what = kind == WA_SLICE_STRING ? "length" : "capacity";
+
+	// 检查的顺序和gc相同: 先检查上界, 再检查下界
+	if (kind == WA_SLICE3 && (uintptr_t)max > (uintptr_t)cap) {
+		snprintf(buf, sizeof(buf), "slice bounds out of range [::%ld] with capacity %ld", (long)max, (long)cap);
+	} else if (kind == WA_SLICE3 && (uintptr_t)hi > (uintptr_t)max) {
+		snprintf(buf, sizeof(buf), "slice bounds out of range [:%ld:%ld]", (long)hi, (long)max);
+	} else if (kind != WA_SLICE3 && (uintptr_t)hi > (uintptr_t)cap) {
+		snprintf(buf, sizeof(buf), "slice bounds out of range [:%ld] with %s %ld", (long)hi, what, (long)cap);
+	} else {
+		snprintf(buf, sizeof(buf), "slice bounds out of range [%ld:%ld]", (long)lo, (long)hi);
+	}
+	wa_runtime_error(buf, pos);
+}
+
+void wa_panic_nil(const char* pos) {
+	wa_runtime_error("invalid memory address or nil pointer dereference", pos);
+}
+
+void wa_panic_divide(const char* pos) {
+	wa_runtime_error("integer divide by zero", pos);
+}
+
+void wa_panic_shift(const char* pos) {
+	wa_runtime_error("negative shift amount", pos);
+}
+
+void wa_panic_msg(const char* msg, const char* pos) {
+	wa_throw("", msg, pos);
+}
+
+void wa_panic_typeassert(const wa_itab* tab, const char* src, const char* want, const char* pos) {
+	char buf[256];
+	if (tab == NULL) {
+		snprintf(buf, sizeof(buf), "interface conversion: %s is nil, not %s", src, want);
+	} else {
+		snprintf(buf, sizeof(buf), "interface conversion: %s is %s, not %s", src, tab->type->name, want);
+	}
+	wa_throw("", buf, pos);
+}
+
+// -----------------------------------------------------------------------------
+// 内存分配和垃圾回收
+//
+// 保守的标记-清除回收器: 栈/全局数据段/堆对象中每个按字对齐的值,
+// 只要指向某个对象的内部(包括对象的首地址), 该对象就是存活的.
+// 对象按分配顺序记录在表中, 回收时按地址排序后二分查找.
+
+typedef struct {
+	uint8_t* ptr;
+	intptr_t size;
+	intptr_t marked;
+} wa_object;
+
+static wa_object* wa_objects;
+static intptr_t wa_nobjects;
+static intptr_t wa_maxobjects;
+
+static intptr_t wa_heap_alloc;                  // 当前分配的字节数
+static intptr_t wa_heap_trigger = WA_GC_MIN_HEAP; // 达到后触发回收
+static int wa_gc_disabled = -1;                 // WA_GC=off时不回收
+static int wa_gc_trace = -1;                    // WA_GCTRACE=1时输出每次回收的统计
+
+// Linux上由链接器和glibc提供: 全局数据段的范围和主线程栈的底部
+extern char __data_start[], _end[];
+extern void* __libc_stack_end;
+
+static void wa_out_of_memory(void) {
+	fprintf(stderr, "fatal error: out of memory\n");
+	exit(2);
+}
+
+static int wa_object_cmp(const void* x, const void* y) {
+	const uint8_t* a = ((const wa_object*)x)->ptr;
+	const uint8_t* b = ((const wa_object*)y)->ptr;
+	return a < b ? -1 : a > b ? 1 : 0;
+}
+
+// 查找地址所在的对象, 不是堆对象时返回-1
+static intptr_t wa_find_object(uintptr_t p) {
+	intptr_t lo = 0, hi = wa_nobjects;
+	while (lo < hi) {
+		intptr_t mid = lo + (hi - lo) / 2;
+		if ((uintptr_t)wa_objects[mid].ptr <= p) {
+			lo = mid + 1;
+		} else {
+			hi = mid;
+		}
+	}
+	if (lo == 0) {
+		return -1;
+	}
+	wa_object* obj = &wa_objects[lo - 1];
+	return p < (uintptr_t)obj->ptr + obj->size ? lo - 1 : -1;
+}
+
+// 标记栈, 保存已经标记但是还没有扫描内容的对象
+static intptr_t* wa_mark_stack;
+static intptr_t wa_mark_len;
+
+static void wa_mark_range(const void* lo, const void* hi) {
+	uintptr_t start = ((uintptr_t)lo + sizeof(void*) - 1) & ~(uintptr_t)(sizeof(void*) - 1);
+	for (uintptr_t p = start; p + sizeof(void*) <= (uintptr_t)hi; p += sizeof(void*)) {
+		intptr_t i = wa_find_object(*(const uintptr_t*)p);
+		if (i >= 0 && !wa_objects[i].marked) {
+			wa_objects[i].marked = 1;
+			wa_mark_stack[wa_mark_len++] = i;
+		}
+	}
+}
+
+static void wa_mark_children(void) {
+	while (wa_mark_len > 0) {
+		wa_object* obj = &wa_objects[wa_mark_stack[--wa_mark_len]];
+		wa_mark_range(obj->ptr, obj->ptr + obj->size);
+	}
+}
+
+// 不内联, 保证寄存器中的值在扫描之前已经保存到栈上
+static __attribute__((noinline)) void wa_mark_stack_roots(void) {
+	__builtin_unwind_init();
+	void* sp = __builtin_frame_address(0);
+	wa_mark_range(sp, __libc_stack_end);
+	wa_mark_children();
+}
+
+void wa_gc(void) {
+	if (wa_nobjects == 0) {
+		return;
+	}
+	intptr_t before = wa_heap_alloc;
+
+	qsort(wa_objects, wa_nobjects, sizeof(wa_object), wa_object_cmp);
+	wa_mark_stack = (intptr_t*)malloc(wa_nobjects * sizeof(intptr_t));
+	if (wa_mark_stack == NULL) {
+		wa_out_of_memory();
+	}
+
+	wa_mark_range(__data_start, _end);
+	wa_mark_children();
+	wa_mark_stack_roots();
+
+	free(wa_mark_stack);
+	wa_mark_stack = NULL;
+
+	// 清除: 释放没有标记的对象, 压缩对象表
+	intptr_t n = 0;
+	for (intptr_t i = 0; i < wa_nobjects; i++) {
+		if (wa_objects[i].marked) {
+			wa_objects[i].marked = 0;
+			wa_objects[n++] = wa_objects[i];
+		} else {
+			wa_heap_alloc -= wa_objects[i].size;
+			free(wa_objects[i].ptr);
+		}
+	}
+	wa_nobjects = n;
+
+	wa_heap_trigger = wa_heap_alloc * 2;
+	if (wa_heap_trigger < WA_GC_MIN_HEAP) {
+		wa_heap_trigger = WA_GC_MIN_HEAP;
+	}
+	if (wa_gc_trace > 0) {
+		fprintf(stderr, "gc: %ld -> %ld bytes, %ld objects\n", (long)before, (long)wa_heap_alloc, (long)n);
+	}
+}
+
+void* wa_alloc(intptr_t size) {
+	if (wa_gc_disabled < 0) {
+		const char* gc = getenv("WA_GC");
+		const char* trace = getenv("WA_GCTRACE");
+		wa_gc_disabled = gc != NULL && strcmp(gc, "off") == 0;
+		wa_gc_trace = trace != NULL && strcmp(trace, "1") == 0;
+	}
+	if (size <= 0) {
+		size = 1;
+	}
+	if (!wa_gc_disabled && wa_heap_alloc + size > wa_heap_trigger) {
+		wa_gc();
+	}
+
+	if (wa_nobjects == wa_maxobjects) {
+		wa_maxobjects = wa_maxobjects > 0 ? wa_maxobjects * 2 : 1024;
+		wa_objects = (wa_object*)realloc(wa_objects, wa_maxobjects * sizeof(wa_object));
+		if (wa_objects == NULL) {
+			wa_out_of_memory();
+		}
+	}
+
+	void* p = calloc(1, (size_t)size);
+	if (p == NULL) {
+		wa_out_of_memory();
+	}
+	wa_objects[wa_nobjects].ptr = (uint8_t*)p;
+	wa_objects[wa_nobjects].size = size;
+	wa_objects[wa_nobjects].marked = 0;
+	wa_nobjects++;
+	wa_heap_alloc += size;
+	return p;
+}
+
+// -----------------------------------------------------------------------------
+// 字符串
+
+void wa_string_concat(wa_string* ret, const uint8_t* xp, intptr_t xn, const uint8_t* yp, intptr_t yn) {
+	if (xn == 0) {
+		ret->ptr = yp;
+		ret->len = yn;
+		return;
+	}
+	if (yn == 0) {
+		ret->ptr = xp;
+		ret->len = xn;
+		return;
+	}
+	uint8_t* p = (uint8_t*)wa_alloc(xn + yn);
+	memcpy(p, xp, xn);
+	memcpy(p + xn, yp, yn);
+	ret->ptr = p;
+	ret->len = xn + yn;
+}
+
+int wa_string_compare(const uint8_t* xp, intptr_t xn, const uint8_t* yp, intptr_t yn) {
+	intptr_t n = xn < yn ? xn : yn;
+	int r = n > 0 ? memcmp(xp, yp, n) : 0;
+	if (r != 0) {
+		return r < 0 ? -1 : 1;
+	}
+	if (xn != yn) {
+		return xn < yn ? -1 : 1;
+	}
+	return 0;
+}
+
+struct wa_string_iter {
+	const uint8_t* p;
+	intptr_t n;
+	intptr_t pos;
+};
+
+wa_string_iter* wa_stringiterinit(const uint8_t* p, intptr_t n) {
+	wa_string_iter* it = (wa_string_iter*)wa_alloc(sizeof(wa_string_iter));
+	it->p = p;
+	it->n = n;
+	return it;
+}
+
+// 解码p[0:n]中的第一个字符, 返回字节数
+// 过长的编码/代理区/超过U+10FFFF的编码和截断的编码都是非法的
+static intptr_t wa_decoderune(const uint8_t* p, intptr_t n, int32_t* r) {
+	uint8_t c = p[0];
+	*r = 0xFFFD;
+	if (c < 0x80) {
+		*r = c;
+		return 1;
+	}
+
+	intptr_t size;
+	int32_t min;
+	uint8_t lo = 0x80, hi = 0xBF; // 第二个字节的范围
+	if (c >= 0xC2 && c <= 0xDF) {
+		size = 2, min = 0x80;
+	} else if (c >= 0xE0 && c <= 0xEF) {
+		size = 3, min = 0x800;
+		if (c == 0xE0) {
+			lo = 0xA0;
+		} else if (c == 0xED) {
+			hi = 0x9F;
+		}
+	} else if (c >= 0xF0 && c <= 0xF4) {
+		size = 4, min = 0x10000;
+		if (c == 0xF0) {
+			lo = 0x90;
+		} else if (c == 0xF4) {
+			hi = 0x8F;
+		}
+	} else {
+		return 1;
+	}
+	if (n < size || p[1] < lo || p[1] > hi) {
+		return 1;
+	}
+
+	int32_t v = c & (0x7F >> size);
+	for (intptr_t i = 1; i < size; i++) {
+		if (p[i] < 0x80 || p[i] > 0xBF) {
+			return 1;
+		}
+		v = v << 6 | (p[i] & 0x3F);
+	}
+	if (v < min) {
+		return 1;
+	}
+	*r = v;
+	return size;
+}
+
+int wa_stringiternext(wa_string_iter* it, intptr_t* key, int32_t* val) {
+	if (it->pos >= it->n) {
+		return 0;
+	}
+	int32_t r;
+	intptr_t size = wa_decoderune(it->p + it->pos, it->n - it->pos, &r);
+	if (key != NULL) {
+		*key = it->pos;
+	}
+	if (val != NULL) {
+		*val = r;
+	}
+	it->pos += size;
+	return 1;
+}
+
+// -----------------------------------------------------------------------------
+// 复数
+
+// 符号取自y, 大小取自x
+static double wa_copysign(double x, double y) {
+	return signbit(x) != signbit(y) ? -x : x;
+}
+
+// Robert L. Smith的算法, 结果都是NaN时按C99的规则修正为无穷大或0
+void wa_complex_div(double* ret, double a, double b, double c, double d) {
+	double e, f;
+	if ((c < 0 ? -c : c) >= (d < 0 ? -d : d)) {
+		double ratio = d / c;
+		double denom = c + ratio * d;
+		e = (a + b * ratio) / denom;
+		f = (b - a * ratio) / denom;
+	} else {
+		double ratio = c / d;
+		double denom = d + ratio * c;
+		e = (a * ratio + b) / denom;
+		f = (b * ratio - a) / denom;
+	}
+
+	if (isnan(e) && isnan(f)) {
+		if (c == 0 && d == 0 && (!isnan(a) || !isnan(b))) {
+			e = wa_copysign(INFINITY, c) * a;
+			f = wa_copysign(INFINITY, c) * b;
+		} else if ((isinf(a) || isinf(b)) && isfinite(c) && isfinite(d)) {
+			a = wa_copysign(isinf(a) ? 1 : 0, a);
+			b = wa_copysign(isinf(b) ? 1 : 0, b);
+			e = INFINITY * (a * c + b * d);
+			f = INFINITY * (b * c - a * d);
+		} else if ((isinf(c) || isinf(d)) && isfinite(a) && isfinite(b)) {
+			c = wa_copysign(isinf(c) ? 1 : 0, c);
+			d = wa_copysign(isinf(d) ? 1 : 0, d);
+			e = 0 * (a * c + b * d);
+			f = 0 * (b * c - a * d);
+		}
+	}
+	ret[0] = e;
+	ret[1] = f;
+}
+
+// -----------------------------------------------------------------------------
+// 切片
+
+void wa_makeslice(wa_slice* ret, intptr_t len, intptr_t cap, intptr_t elemsize, const char* pos) {
+	if (len < 0) {
+		wa_runtime_error("makeslice: len out of range", pos);
+	}
+	if (cap < len) {
+		wa_runtime_error("makeslice: cap out of range", pos);
+	}
+	ret->ptr = wa_alloc(cap * elemsize);
+	ret->len = len;
+	ret->cap = cap;
+}
+
+// 容量的增长策略和gc相同: 小切片翻倍, 大切片每次增长1.25倍
+static intptr_t wa_growcap(intptr_t oldcap, intptr_t needed) {
+	intptr_t newcap = oldcap;
+	if (needed > oldcap * 2) {
+		return needed;
+	}
+	const intptr_t threshold = 256;
+	if (oldcap < threshold) {
+		return oldcap * 2;
+	}
+	while (newcap < needed) {
+		newcap += (newcap + 3 * threshold) / 4;
+	}
+	return newcap;
+}
+
+void wa_append(wa_slice* ret, void* ptr, intptr_t len, intptr_t cap, const void* eptr, intptr_t elen, intptr_t elemsize) {
+	intptr_t newlen = len + elen;
+	if (newlen > cap) {
+		intptr_t newcap = wa_growcap(cap, newlen);
+		void* p = wa_alloc(newcap * elemsize);
+		if (len > 0) {
+			memcpy(p, ptr, len * elemsize);
+		}
+		ptr = p;
+		cap = newcap;
+	}
+	if (elen > 0) {
+		memmove((uint8_t*)ptr + len * elemsize, eptr, elen * elemsize);
+	}
+	ret->ptr = ptr;
+	ret->len = newlen;
+	ret->cap = cap;
+}
+
+// -----------------------------------------------------------------------------
+// map: 链表法处理冲突的哈希表, 键和值紧跟在节点后面
+
+typedef struct wa_map_entry {
+	struct wa_map_entry* next;
+	uint64_t hash;
+} wa_map_entry;
+
+struct wa_map {
+	intptr_t count;
+	intptr_t keysize;
+	intptr_t valsize;
+	int keykind;
+	wa_hash_func hash;
+	wa_equal_func equal;
+	intptr_t nbuckets;
+	wa_map_entry** buckets;
+};
+
+struct wa_map_iter {
+	wa_map* m;
+	intptr_t bucket;
+	wa_map_entry* next;
+};
+
+#define WA_ENTRY_KEY(e) ((uint8_t*)((e) + 1))
+#define WA_ENTRY_VAL(m, e) (WA_ENTRY_KEY(e) + ((m)->keysize + 7) / 8 * 8)
+
+#define WA_HASH_SEED 14695981039346656037ULL
+
+static uint64_t wa_hash_bytes(uint64_t h, const void* p, intptr_t n) {
+	const uint8_t* b = (const uint8_t*)p;
+	for (intptr_t i = 0; i < n; i++) { // FNV-1a
+		h ^= b[i];
+		h *= 1099511628211ULL;
+	}
+	return h;
+}
+
+uint64_t wa_hash_int(uint64_t h, uint64_t x) {
+	return wa_hash_bytes(h, &x, sizeof(x));
+}
+
+uint64_t wa_hash_float(uint64_t h, double x) {
+	if (x == 0) {
+		x = 0; // +0和-0的哈希值相同
+	}
+	return wa_hash_bytes(h, &x, sizeof(x));
+}
+
+uint64_t wa_hash_string(uint64_t h, const uint8_t* p, intptr_t n) {
+	return wa_hash_bytes(h, p, n);
+}
+
+static uint64_t wa_map_hash(wa_map* m, const void* key, const char* pos) {
+	switch (m->keykind) {
+	case WA_KEY_STRING: {
+		const wa_string* s = (const wa_string*)key;
+		return wa_hash_string(WA_HASH_SEED, s->ptr, s->len);
+	}
+	case WA_KEY_FLOAT32:
+		return wa_hash_float(WA_HASH_SEED, *(const float*)key);
+	case WA_KEY_FLOAT64:
+		return wa_hash_float(WA_HASH_SEED, *(const double*)key);
+	case WA_KEY_FUNC:
+		return m->hash(WA_HASH_SEED, key, pos);
+	}
+	return wa_hash_bytes(WA_HASH_SEED, key, m->keysize);
+}
+
+static int wa_map_equal(wa_map* m, const void* x, const void* y) {
+	switch (m->keykind) {
+	case WA_KEY_STRING: {
+		const wa_string* a = (const wa_string*)x;
+		const wa_string* b = (const wa_string*)y;
+		return wa_string_compare(a->ptr, a->len, b->ptr, b->len) == 0;
+	}
+	case WA_KEY_FLOAT32:
+		return *(const float*)x == *(const float*)y;
+	case WA_KEY_FLOAT64:
+		return *(const double*)x == *(const double*)y;
+	case WA_KEY_FUNC:
+		return m->equal(x, y);
+	}
+	return memcmp(x, y, m->keysize) == 0;
+}
+
+wa_map* wa_makemap(intptr_t keysize, intptr_t valsize, int keykind, wa_hash_func hash, wa_equal_func equal) {
+	wa_map* m = (wa_map*)wa_alloc(sizeof(wa_map));
+	m->keysize = keysize;
+	m->valsize = valsize;
+	m->keykind = keykind;
+	m->hash = hash;
+	m->equal = equal;
+	m->nbuckets = 8;
+	m->buckets = (wa_map_entry**)wa_alloc(m->nbuckets * sizeof(wa_map_entry*));
+	return m;
+}
+
+intptr_t wa_maplen(wa_map* m) {
+	return m != NULL ? m->count : 0;
+}
+
+static wa_map_entry* wa_map_find(wa_map* m, const void* key, uint64_t hash) {
+	for (wa_map_entry* e = m->buckets[hash % m->nbuckets]; e != NULL; e = e->next) {
+		if (e->hash == hash && wa_map_equal(m, WA_ENTRY_KEY(e), key)) {
+			return e;
+		}
+	}
+	return NULL;
+}
+
+void* wa_mapaccess(wa_map* m, const void* key, const char* pos) {
+	if (m == NULL) {
+		return NULL;
+	}
+	uint64_t hash = wa_map_hash(m, key, pos); // 空map也要检查键是否可哈希
+	if (m->count == 0) {
+		return NULL;
+	}
+	wa_map_entry* e = wa_map_find(m, key, hash);
+	return e != NULL ? WA_ENTRY_VAL(m, e) : NULL;
+}
+
+static void wa_map_grow(wa_map* m) {
+	intptr_t n = m->nbuckets * 2;
+	wa_map_entry** buckets = (wa_map_entry**)wa_alloc(n * sizeof(wa_map_entry*));
+	for (intptr_t i = 0; i < m->nbuckets; i++) {
+		wa_map_entry* e = m->buckets[i];
+		while (e != NULL) {
+			wa_map_entry* next = e->next;
+			e->next = buckets[e->hash % n];
+			buckets[e->hash % n] = e;
+			e = next;
+		}
+	}
+	m->buckets = buckets;
+	m->nbuckets = n;
+}
+
+void* wa_mapassign(wa_map* m, const void* key, const char* pos) {
+	if (m == NULL) {
+		wa_panic_msg("assignment to entry in nil map", pos);
+	}
+	uint64_t hash = wa_map_hash(m, key, pos);
+	wa_map_entry* e = wa_map_find(m, key, hash);
+	if (e != NULL) {
+		return WA_ENTRY_VAL(m, e);
+	}
+
+	if (m->count >= m->nbuckets * 2) {
+		wa_map_grow(m);
+	}
+	e = (wa_map_entry*)wa_alloc(sizeof(wa_map_entry) + (m->keysize + 7) / 8 * 8 + m->valsize);
+	e->hash = hash;
+	memcpy(WA_ENTRY_KEY(e), key, m->keysize);
+	e->next = m->buckets[hash % m->nbuckets];
+	m->buckets[hash % m->nbuckets] = e;
+	m->count++;
+	return WA_ENTRY_VAL(m, e);
+}
+
+void wa_mapdelete(wa_map* m, const void* key, const char* pos) {
+	if (m == NULL) {
+		return;
+	}
+	uint64_t hash = wa_map_hash(m, key, pos);
+	if (m->count == 0) {
+		return;
+	}
+	for (wa_map_entry** pe = &m->buckets[hash % m->nbuckets]; *pe != NULL; pe = &(*pe)->next) {
+		wa_map_entry* e = *pe;
+		if (e->hash == hash && wa_map_equal(m, WA_ENTRY_KEY(e), key)) {
+			*pe = e->next;
+			m->count--;
+			return;
+		}
+	}
+}
+
+wa_map_iter* wa_mapiterinit(wa_map* m) {
+	wa_map_iter* it = (wa_map_iter*)wa_alloc(sizeof(wa_map_iter));
+	it->m = m;
+	it->bucket = -1;
+	return it;
+}
+
+int wa_mapiternext(wa_map_iter* it, void* key, void* val) {
+	wa_map* m = it->m;
+	if (m == NULL) {
+		return 0;
+	}
+	while (it->next == NULL) {
+		if (++it->bucket >= m->nbuckets) {
+			return 0;
+		}
+		it->next = m->buckets[it->bucket];
+	}
+	wa_map_entry* e = it->next;
+	it->next = e->next;
+	if (key != NULL) {
+		memcpy(key, WA_ENTRY_KEY(e), m->keysize);
+	}
+	if (val != NULL) {
+		memcpy(val, WA_ENTRY_VAL(m, e), m->valsize);
+	}
+	return 1;
+}
+
+// -----------------------------------------------------------------------------
+// 接口
+
+// 运行时生成的itab, 以接口和动态类型为键缓存
+typedef struct wa_itab_entry {
+	struct wa_itab_entry* next;
+	const wa_itype* inter;
+	wa_itab* tab;
+} wa_itab_entry;
+
+static wa_itab_entry* wa_itabs;
+
+const wa_type* wa_typeof(const wa_itab* tab) {
+	return tab != NULL ? tab->type : NULL;
+}
+
+// 查找动态类型实现接口的方法, 缺少方法时返回NULL并输出缺少的方法名
+static wa_itab* wa_getitab(const wa_itype* inter, const wa_type* t, const char** missing) {
+	for (wa_itab_entry* e = wa_itabs; e != NULL; e = e->next) {
+		if (e->inter == inter && e->tab->type == t) {
+			return e->tab;
+		}
+	}
+
+	wa_itab* tab = (wa_itab*)wa_alloc(sizeof(wa_itab) + inter->nmethods * sizeof(void*));
+	tab->type = t;
+	for (intptr_t i = 0; i < inter->nmethods; i++) {
+		for (intptr_t j = 0; j < t->nmethods; j++) {
+			if (strcmp(t->methods[j].name, inter->methods[i]) == 0) {
+				tab->fun[i] = t->methods[j].fn;
+				break;
+			}
+		}
+		if (tab->fun[i] == NULL) {
+			*missing = inter->methods[i];
+			return NULL;
+		}
+	}
+
+	wa_itab_entry* e = (wa_itab_entry*)wa_alloc(sizeof(wa_itab_entry));
+	e->inter = inter;
+	e->tab = tab;
+	e->next = wa_itabs;
+	wa_itabs = e;
+	return tab;
+}
+
+// 接口之间的转换, 静态类型检查已经保证动态类型实现了目标接口
+wa_itab* wa_convI2I(const wa_itype* inter, const wa_itab* tab) {
+	const char* missing = NULL;
+	if (tab == NULL) {
+		return NULL;
+	}
+	return wa_getitab(inter, tab->type, &missing);
+}
+
+// 断言为接口类型, canfail为0时失败会panic
+wa_itab* wa_assertI2I(const wa_itype* inter, const wa_itab* tab, const char* src, int canfail, const char* pos) {
+	const char* missing = NULL;
+	wa_itab* result = NULL;
+	if (tab != NULL) {
+		result = wa_getitab(inter, tab->type, &missing);
+	}
+	if (result != NULL || canfail) {
+		return result;
+	}
+
+	if (tab == NULL) {
+		wa_panic_typeassert(tab, src, inter->name, pos);
+	}
+	char buf[256];
+	snprintf(buf, sizeof(buf), "interface conversion: %s is not %s: missing method %s",
+		tab->type->name, inter->name, missing);
+	wa_throw("", buf, pos);
+	return NULL;
+}
+
+// 接口值的比较, 动态类型相同并且值相等
+int32_t wa_ifaceeq(const wa_itab* xtab, const void* xdata, const wa_itab* ytab, const void* ydata, const char* pos) {
+	const wa_type* t = wa_typeof(xtab);
+	if (t != wa_typeof(ytab)) {
+		return 0;
+	}
+	if (t == NULL) {
+		return 1;
+	}
+	if ((t->flags & WA_TYPE_COMPARABLE) == 0) {
+		char buf[256];
+		snprintf(buf, sizeof(buf), "comparing uncomparable type %s", t->name);
+		wa_runtime_error(buf, pos);
+	}
+	if ((t->flags & WA_TYPE_DIRECT) != 0) {
+		return xdata == ydata;
+	}
+	return t->equal(xdata, ydata);
+}
+
+uint64_t wa_hash_iface(uint64_t h, const wa_itab* tab, const void* data, const char* pos) {
+	const wa_type* t = wa_typeof(tab);
+	if (t == NULL) {
+		return wa_hash_int(h, 0);
+	}
+	if ((t->flags & WA_TYPE_COMPARABLE) == 0) {
+		char buf[256];
+		snprintf(buf, sizeof(buf), "hash of unhashable type %s", t->name);
+		wa_runtime_error(buf, pos);
+	}
+	h = wa_hash_int(h, (uintptr_t)t);
+	if ((t->flags & WA_TYPE_DIRECT) != 0) {
+		return wa_hash_int(h, (uintptr_t)data);
+	}
+	return t->hash(h, data, pos);
+}
+
+// -----------------------------------------------------------------------------
+// print/println
+// 格式和gc的runtime/print.go相同, 为了和解释器一致输出到标准输出, panic信息输出到标准错误
+
+static FILE* wa_print_file;
+
+static FILE* wa_out(void) {
+	return wa_print_file != NULL ? wa_print_file : stdout;
+}
+
+void wa_print_bool(int8_t v) {
+	fputs(v ? "true" : "false", wa_out());
+}
+
+void wa_print_int(int64_t v) {
+	fprintf(wa_out(), "%lld", (long long)v);
+}
+
+void wa_print_uint(uint64_t v) {
+	fprintf(wa_out(), "%llu", (unsigned long long)v);
+}
+
+// 输出+d.dddddde+ddd格式的浮点数
+void wa_print_float(double v) {
+	if (v != v) {
+		fputs("NaN", wa_out());
+		return;
+	}
+	if (v + v == v && v > 0) {
+		fputs("+Inf", wa_out());
+		return;
+	}
+	if (v + v == v && v < 0) {
+		fputs("-Inf", wa_out());
+		return;
+	}
+
+	enum { n = 7 }; // 输出的有效数字
+	char buf[n + 7];
+	buf[0] = '+';
+	int e = 0; // 指数
+	if (v == 0) {
+		if (1 / v < 0) {
+			buf[0] = '-';
+		}
+	} else {
+		if (v < 0) {
+			v = -v;
+			buf[0] = '-';
+		}
+
+		// 规格化
+		while (v >= 10) {
+			e++;
+			v /= 10;
+		}
+		while (v < 1) {
+			e--;
+			v *= 10;
+		}
+
+		// 舍入
+		double h = 5.0;
+		for (int i = 0; i < n; i++) {
+			h /= 10;
+		}
+		v += h;
+		if (v >= 10) {
+			e++;
+			v /= 10;
+		}
+	}
+
+	for (int i = 0; i < n; i++) {
+		int s = (int)v;
+		buf[i + 2] = (char)(s + '0');
+		v -= s;
+		v *= 10;
+	}
+	buf[1] = buf[2];
+	buf[2] = '.';
+
+	buf[n + 2] = 'e';
+	buf[n + 3] = '+';
+	if (e < 0) {
+		e = -e;
+		buf[n + 3] = '-';
+	}
+	buf[n + 4] = (char)(e / 100 + '0');
+	buf[n + 5] = (char)(e / 10 % 10 + '0');
+	buf[n + 6] = (char)(e % 10 + '0');
+	fwrite(buf, 1, sizeof(buf), wa_out());
+}
+
+void wa_print_complex(double re, double im) {
+	fputs("(", wa_out());
+	wa_print_float(re);
+	wa_print_float(im);
+	fputs("i)", wa_out());
+}
+
+void wa_print_string(const uint8_t* p, intptr_t n) {
+	if (n > 0) {
+		fwrite(p, 1, n, wa_out());
+	}
+}
+
+void wa_print_pointer(const void* p) {
+	fprintf(wa_out(), "0x%lx", (unsigned long)(uintptr_t)p);
+}
+
+void wa_print_slice(const void* p, intptr_t len, intptr_t cap) {
+	fprintf(wa_out(), "[%ld/%ld]", (long)len, (long)cap);
+	wa_print_pointer(p);
+}
+
+void wa_print_eface(const void* t, const void* data) {
+	fputs("(", wa_out());
+	wa_print_pointer(t);
+	fputs(",", wa_out());
+	wa_print_pointer(data);
+	fputs(")", wa_out());
+}
+
+void wa_print_space(void) {
+	fputs(" ", wa_out());
+}
+
+void wa_print_nl(void) {
+	fputs("\n", wa_out());
+}
+
+// -----------------------------------------------------------------------------
+// panic/defer/recover
+
+struct wa_defer {
+	wa_defer* next;
+	void (*fn)(void* ctx);
+	void* ctx;
+};
+
+typedef struct wa_panic_record {
+	struct wa_panic_record* link; // 执行延迟调用时发生新的panic, 链接到之前的panic
+	const wa_itab* tab;
+	void* data;
+	const char* pos;
+	wa_frame* frame; // 正在执行延迟调用的栈帧
+	int recovered;
+	int repanicked; // 恢复之后又以相同的值panic
+} wa_panic_record;
+
+static wa_frame* wa_frames;        // 最内层的栈帧
+static wa_panic_record* wa_panics; // 正在处理的panic
+
+wa_frame* wa_frame_enter(void) {
+	wa_frame* f = (wa_frame*)wa_alloc(sizeof(wa_frame));
+	f->prev = wa_frames;
+	wa_frames = f;
+	return f;
+}
+
+void wa_frame_leave(wa_frame* f) {
+	wa_frames = f->prev;
+}
+
+void wa_defer_push(wa_frame* f, void (*fn)(void* ctx), void* ctx) {
+	wa_defer* d = (wa_defer*)wa_alloc(sizeof(wa_defer));
+	d->fn = fn;
+	d->ctx = ctx;
+	d->next = f->defers;
+	f->defers = d;
+}
+
+void wa_rundefers(wa_frame* f) {
+	while (f->defers != NULL) {
+		wa_defer* d = f->defers;
+		f->defers = d->next;
+		d->fn(d->ctx);
+	}
+}
+
+static void* wa_defer_callee; // 延迟调用即将调用的函数
+
+void wa_defer_arm(void* fn) {
+	wa_defer_callee = fn;
+}
+
+int32_t wa_defer_enter(void* fn) {
+	if (wa_defer_callee != fn) {
+		return 0;
+	}
+	wa_defer_callee = NULL;
+	return 1;
+}
+
+void wa_defer_forward(void* wrapper, void* fn) {
+	if (wa_defer_callee == wrapper) {
+		wa_defer_callee = fn;
+	}
+}
+
+// 只有panic过程中被延迟调用直接调用的函数可以恢复, 其它情况返回nil
+void wa_recover(void* ret[2], int32_t canrecover) {
+	wa_panic_record* p = wa_panics;
+	ret[0] = NULL;
+	ret[1] = NULL;
+	if (canrecover && p != NULL && !p->recovered) {
+		p->recovered = 1;
+		ret[0] = (void*)p->tab;
+		ret[1] = p->data;
+	}
+}
+
+// outer是否是f外层的栈帧
+static int wa_frame_outer(wa_frame* outer, wa_frame* f) {
+	for (f = f->prev; f != NULL; f = f->prev) {
+		if (f == outer) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+static const void* wa_find_method(const wa_type* t, const char* name) {
+	for (intptr_t i = 0; i < t->nmethods; i++) {
+		if (strcmp(t->methods[i].name, name) == 0) {
+			return t->methods[i].fn;
+		}
+	}
+	return NULL;
+}
+
+// 输出panic的值, 规则和gc的printpanicval相同:
+// error和Stringer输出方法的结果, 基础类型输出值, 命名的基础类型输出为T(v), 其它类型输出类型和地址
+static void wa_print_panic_value(const wa_itab* tab, void* data) {
+	const wa_type* t = tab->type;
+	const void* fn = wa_find_method(t, "Error");
+	if (fn == NULL) {
+		fn = wa_find_method(t, "String");
+	}
+	if (fn != NULL) {
+		wa_string s = ((wa_string(*)(void*))fn)(data);
+		wa_print_string(s.ptr, s.len);
+		return;
+	}
+
+	if (t->kind == WA_KIND_OTHER) {
+		fprintf(wa_out(), "(%s) ", t->name);
+		wa_print_pointer(data);
+		return;
+	}
+
+	int named = (t->flags & WA_TYPE_BASIC) == 0;
+	if (named) {
+		fprintf(wa_out(), "%s(", t->name);
+	}
+	switch (t->kind) {
+	case WA_KIND_BOOL:
+		wa_print_bool(*(const int8_t*)data);
+		break;
+	case WA_KIND_INT:
+		switch (t->size) {
+		case 1: wa_print_int(*(const int8_t*)data); break;
+		case 2: wa_print_int(*(const int16_t*)data); break;
+		case 4: wa_print_int(*(const int32_t*)data); break;
+		default: wa_print_int(*(const int64_t*)data); break;
+		}
+		break;
+	case WA_KIND_UINT:
+		switch (t->size) {
+		case 1: wa_print_uint(*(const uint8_t*)data); break;
+		case 2: wa_print_uint(*(const uint16_t*)data); break;
+		case 4: wa_print_uint(*(const uint32_t*)data); break;
+		default: wa_print_uint(*(const uint64_t*)data); break;
+		}
+		break;
+	case WA_KIND_FLOAT:
+		wa_print_float(t->size == 4 ? *(const float*)data : *(const double*)data);
+		break;
+	case WA_KIND_COMPLEX:
+		if (t->size == 8) {
+			wa_print_complex(((const float*)data)[0], ((const float*)data)[1]);
+		} else {
+			wa_print_complex(((const double*)data)[0], ((const double*)data)[1]);
+		}
+		break;
+	case WA_KIND_STRING: {
+		const wa_string* s = (const wa_string*)data;
+		if (named) {
+			fputs("\"", wa_out());
+		}
+		wa_print_string(s->ptr, s->len);
+		if (named) {
+			fputs("\"", wa_out());
+		}
+		break;
+	}
+	}
+	if (named) {
+		fputs(")", wa_out());
+	}
+}
+
+// 和gc一样, 以相同的值再次panic时只输出一次, 标记为[recovered, repanicked]
+static void wa_print_panics(wa_panic_record* p) {
+	if (p->link != NULL) {
+		wa_print_panics(p->link);
+		if (p->link->repanicked) {
+			return;
+		}
+		fputs("\t", wa_out());
+	}
+	fputs("panic: ", wa_out());
+	wa_print_panic_value(p->tab, p->data);
+	if (p->recovered && p->repanicked) {
+		fputs(" [recovered, repanicked]", wa_out());
+	} else if (p->recovered) {
+		fputs(" [recovered]", wa_out());
+	}
+	fputs("\n", wa_out());
+}
+
+void wa_panic(const wa_itab* tab, void* data, const char* pos) {
+	if (tab == NULL) {
+		wa_runtime_error("panic called with nil argument", pos);
+	}
+
+	wa_panic_record* p = (wa_panic_record*)wa_alloc(sizeof(wa_panic_record));
+	p->tab = tab;
+	p->data = data;
+	p->pos = pos;
+	p->link = wa_panics;
+	wa_panics = p;
+
+	for (wa_frame* f = wa_frames; f != NULL; f = f->prev) {
+		wa_frames = f;
+		p->frame = f;
+		while (f->defers != NULL) {
+			wa_defer* d = f->defers;
+			f->defers = d->next;
+			d->fn(d->ctx);
+			if (!p->recovered) {
+				continue;
+			}
+
+			// 回到f之后, 在f或者更内层的栈帧中处理的panic都已经终止
+			wa_panic_record* q = p->link;
+			while (q != NULL && !wa_frame_outer(q->frame, f)) {
+				q = q->link;
+			}
+			wa_panics = q;
+			wa_rundefers(f);
+			longjmp(f->jb, 1);
+		}
+	}
+
+	for (wa_panic_record* q = p; q->link != NULL; q = q->link) {
+		if (q->link->tab->type == q->tab->type && q->link->data == q->data) {
+			q->link->repanicked = 1;
+		}
+	}
+	fflush(stdout);
+	wa_print_file = stderr;
+	wa_print_panics(p);
+	fputs("\ngoroutine 1 [running]:\n", stderr);
+	if (pos != NULL && pos[0] != '\0') {
+		fprintf(stderr, "\t%s\n", pos);
+	}
+	exit(2);
+}
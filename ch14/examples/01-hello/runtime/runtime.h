@@ -0,0 +1,195 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+// 凹语言本地代码的运行时库
+//
+// LLVM后端生成的代码通过下面的函数使用字符串/切片/map等运行时功能,
+// 所有的参数都是标量或指针, 避免不同平台上结构体传参规则的差异.
+
+#ifndef WA_RUNTIME_H_
+#define WA_RUNTIME_H_
+
+#include <setjmp.h>
+#include <stdint.h>
+
+typedef struct {
+	const uint8_t* ptr;
+	intptr_t len;
+} wa_string;
+
+typedef struct {
+	void* ptr;
+	intptr_t len;
+	intptr_t cap;
+} wa_slice;
+
+typedef struct wa_map wa_map;
+typedef struct wa_map_iter wa_map_iter;
+
+// map键的比较方式
+enum {
+	WA_KEY_MEM = 0,     // 按内存比较
+	WA_KEY_STRING = 1,  // 字符串
+	WA_KEY_FLOAT32 = 2, // +0和-0相等, NaN和任何值都不相等
+	WA_KEY_FLOAT64 = 3,
+	WA_KEY_FUNC = 4,    // 使用生成的哈希函数和比较函数: 复数/结构体/数组/接口
+};
+
+// 生成的哈希函数将*p混合到哈希值h中, 键中的接口值不可哈希时在pos处panic
+typedef uint64_t (*wa_hash_func)(uint64_t h, const void* p, const char* pos);
+typedef int32_t (*wa_equal_func)(const void* x, const void* y);
+
+// 内存分配, 返回的内存已经清零
+// 分配的字节数超过上次回收后存活字节数的2倍(至少WA_GC_MIN_HEAP)时触发垃圾回收
+#define WA_GC_MIN_HEAP (4 << 20)
+
+void* wa_alloc(intptr_t size);
+void wa_gc(void);
+
+// 字符串
+void wa_string_concat(wa_string* ret, const uint8_t* xp, intptr_t xn, const uint8_t* yp, intptr_t yn);
+int wa_string_compare(const uint8_t* xp, intptr_t xn, const uint8_t* yp, intptr_t yn);
+
+// 字符串的for range迭代器, 按UTF-8解码, 非法的编码和Go一样解码为U+FFFD, 长度为1
+typedef struct wa_string_iter wa_string_iter;
+wa_string_iter* wa_stringiterinit(const uint8_t* p, intptr_t n);
+int wa_stringiternext(wa_string_iter* it, intptr_t* key, int32_t* val);
+
+// 复数除法, 结果写入ret[0]和ret[1], 和gc的complex128div相同
+void wa_complex_div(double* ret, double a, double b, double c, double d);
+
+// 切片
+void wa_makeslice(wa_slice* ret, intptr_t len, intptr_t cap, intptr_t elemsize, const char* pos);
+void wa_append(wa_slice* ret, void* ptr, intptr_t len, intptr_t cap, const void* eptr, intptr_t elen, intptr_t elemsize);
+
+// map
+// keykind为WA_KEY_FUNC时使用hash和equal, 否则两者为NULL
+wa_map* wa_makemap(intptr_t keysize, intptr_t valsize, int keykind, wa_hash_func hash, wa_equal_func equal);
+intptr_t wa_maplen(wa_map* m);
+void* wa_mapaccess(wa_map* m, const void* key, const char* pos);
+void* wa_mapassign(wa_map* m, const void* key, const char* pos);
+void wa_mapdelete(wa_map* m, const void* key, const char* pos);
+wa_map_iter* wa_mapiterinit(wa_map* m);
+int wa_mapiternext(wa_map_iter* it, void* key, void* val);
+
+// print/println, 输出格式和gc相同
+void wa_print_bool(int8_t v);
+void wa_print_int(int64_t v);
+void wa_print_uint(uint64_t v);
+void wa_print_float(double v);
+void wa_print_complex(double re, double im);
+void wa_print_string(const uint8_t* p, intptr_t n);
+void wa_print_pointer(const void* p);
+void wa_print_slice(const void* p, intptr_t len, intptr_t cap);
+void wa_print_eface(const void* t, const void* data);
+void wa_print_space(void);
+void wa_print_nl(void);
+
+// 接口
+//
+// 接口值由两个指针组成: itab和数据指针. 指针类型的值直接保存在数据指针中,
+// 其它类型的值复制到堆上. itab的第一项是动态类型, 后面是按方法名排序的方法,
+// 方法的第一个参数是数据指针.
+
+enum {
+	WA_TYPE_DIRECT = 1,     // 值直接保存在数据指针中
+	WA_TYPE_COMPARABLE = 2, // 可以比较
+	WA_TYPE_BASIC = 4,      // 未命名的基础类型
+};
+
+// 类型的种类, 用于输出panic的值
+enum {
+	WA_KIND_OTHER = 0,
+	WA_KIND_BOOL = 1,
+	WA_KIND_INT = 2,
+	WA_KIND_UINT = 3,
+	WA_KIND_FLOAT = 4,
+	WA_KIND_COMPLEX = 5,
+	WA_KIND_STRING = 6,
+};
+
+typedef struct {
+	const char* name; // 方法名, 非导出的方法带包路径
+	void* fn;
+} wa_method;
+
+// 具体类型的描述符
+typedef struct wa_type {
+	const char* name;
+	intptr_t size;
+	intptr_t flags;
+	intptr_t kind;
+	wa_equal_func equal; // 非直接保存的可比较类型
+	wa_hash_func hash;
+	intptr_t nmethods;
+	const wa_method* methods;
+} wa_type;
+
+// 接口类型的描述符
+typedef struct {
+	const char* name;
+	intptr_t nmethods;
+	const char* methods[];
+} wa_itype;
+
+typedef struct {
+	const wa_type* type;
+	void* fun[];
+} wa_itab;
+
+const wa_type* wa_typeof(const wa_itab* tab);
+wa_itab* wa_convI2I(const wa_itype* inter, const wa_itab* tab);
+wa_itab* wa_assertI2I(const wa_itype* inter, const wa_itab* tab, const char* src, int canfail, const char* pos);
+int32_t wa_ifaceeq(const wa_itab* xtab, const void* xdata, const wa_itab* ytab, const void* ydata, const char* pos);
+
+// 生成的哈希函数使用的辅助函数, 将x混合到哈希值h中
+uint64_t wa_hash_int(uint64_t h, uint64_t x);
+uint64_t wa_hash_float(uint64_t h, double x);
+uint64_t wa_hash_string(uint64_t h, const uint8_t* p, intptr_t n);
+uint64_t wa_hash_iface(uint64_t h, const wa_itab* tab, const void* data, const char* pos);
+
+// panic/defer/recover
+//
+// 有defer语句的函数在入口调用wa_frame_enter注册栈帧, 然后用_setjmp保存恢复点.
+// panic时从最内层的栈帧开始依次执行延迟调用; 延迟调用中recover之后,
+// 继续执行该栈帧剩余的延迟调用, 然后通过longjmp回到函数的恢复点返回.
+
+typedef struct wa_defer wa_defer;
+
+typedef struct wa_frame {
+	jmp_buf jb; // 必须是第一个成员, 生成的代码直接将栈帧的地址传给_setjmp
+	struct wa_frame* prev;
+	wa_defer* defers;
+} wa_frame;
+
+wa_frame* wa_frame_enter(void);
+void wa_frame_leave(wa_frame* f);
+void wa_defer_push(wa_frame* f, void (*fn)(void* ctx), void* ctx);
+void wa_rundefers(wa_frame* f);
+void wa_panic(const wa_itab* tab, void* data, const char* pos) __attribute__((noreturn));
+void wa_recover(void* ret[2], int32_t canrecover);
+
+// recover只在延迟调用直接调用的函数中有效: 延迟调用在调用之前登记被调用的函数,
+// 调用recover的函数在入口检查登记的是否是自己, 检查的结果作为wa_recover的canrecover参数.
+// 函数值和接口方法的包装函数把登记转交给被包装的函数.
+void wa_defer_arm(void* fn);
+int32_t wa_defer_enter(void* fn);
+void wa_defer_forward(void* wrapper, void* fn);
+
+// 切片运算的种类, 影响越界时的错误信息
+enum {
+	WA_SLICE2 = 0,      // s[lo:hi]
+	WA_SLICE3 = 1,      // s[lo:hi:max]
+	WA_SLICE_STRING = 2 // 字符串s[lo:hi]
+};
+
+// 运行时错误, 输出Go格式的panic信息后以状态码2退出
+// pos是"文件名:行:列"格式的源码位置
+void wa_panic_index(intptr_t i, intptr_t len, const char* pos);
+void wa_panic_slice(intptr_t lo, intptr_t hi, intptr_t max, intptr_t cap, int kind, const char* pos);
+void wa_panic_nil(const char* pos);
+void wa_panic_divide(const char* pos);
+void wa_panic_shift(const char* pos);
+void wa_panic_msg(const char* msg, const char* pos);
+void wa_panic_typeassert(const wa_itab* tab, const char* src, const char* want, const char* pos);
+
+#endif // WA_RUNTIME_H_
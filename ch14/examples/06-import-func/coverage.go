@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"html"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// 覆盖率统计, 记录每个基本块的执行次数
+type Coverage struct {
+	fset   *token.FileSet
+	funcs  []*ssa.Function         // 有源码的函数, 包括泛型函数的实例
+	counts map[*ssa.BasicBlock]int // 基本块的执行次数
+}
+
+// 覆盖率统计的源码区间
+type coverBlock struct {
+	file       string         // 源文件名, 和FileSet中的相同
+	name       string         // 覆盖率文件中的文件名: 包路径/文件名
+	start, end token.Position // end为区间结束之后的位置
+	numStmts   int
+	count      int
+}
+
+func NewCoverage(prog *ssa.Program) *Coverage {
+	c := &Coverage{
+		fset:   prog.Fset,
+		counts: make(map[*ssa.BasicBlock]int),
+	}
+	for fn := range ssautil.AllFunctions(prog) {
+		// 包装函数等没有源码
+		if fn.Syntax() == nil || len(fn.Blocks) == 0 {
+			continue
+		}
+		c.funcs = append(c.funcs, fn)
+	}
+	return c
+}
+
+// 进入基本块
+func (c *Coverage) hit(b *ssa.BasicBlock) {
+	c.counts[b]++
+}
+
+// 一个函数(FuncDecl或者FuncLit)的执行次数
+// 泛型函数的多个实例共用源码, 执行次数相加
+type coverFunc struct {
+	entry  int               // 函数的执行次数
+	counts map[token.Pos]int // 每个位置上指令的执行次数
+	points []token.Pos       // 排序后的指令位置
+}
+
+// 和cmd/cover一样按语句划分覆盖率统计的区间:
+// 函数体和复合语句中的语句列表在控制流语句处切分, 每个区间包含若干条顺序执行的语句,
+// 以控制流语句结束时区间延伸到语句体的'{'之前, 语句体再单独划分区间.
+// 区间的执行次数为其中指令的最大执行次数, 没有指令的区间(比如只有常量赋值)取外层区间的次数,
+// 循环的条件和后置语句每次迭代都执行, 不计入循环之前的区间.
+func (c *Coverage) profileBlocks() []coverBlock {
+	funcs := make(map[ast.Node]*coverFunc)
+	var decls []*ast.FuncDecl
+	pkgs := make(map[*ast.FuncDecl]string)
+	for _, fn := range c.funcs {
+		syntax := fn.Syntax()
+		cf := funcs[syntax]
+		if cf == nil {
+			cf = &coverFunc{counts: make(map[token.Pos]int)}
+			funcs[syntax] = cf
+			if decl, ok := syntax.(*ast.FuncDecl); ok {
+				decls = append(decls, decl)
+				pkgs[decl] = fn.Pkg.Pkg.Path()
+			}
+		}
+
+		// 同一位置的指令可能分布在多个基本块中, 取最大的执行次数
+		counts := make(map[token.Pos]int)
+		for _, b := range fn.Blocks {
+			for _, ins := range b.Instrs {
+				// phi的位置是变量的声明, 不是在这个基本块中执行的语句
+				if _, ok := ins.(*ssa.Phi); ok {
+					continue
+				}
+				pos := ins.Pos()
+				if !pos.IsValid() {
+					continue
+				}
+				if n, ok := counts[pos]; !ok || c.counts[b] > n {
+					counts[pos] = c.counts[b]
+				}
+			}
+		}
+		for pos, n := range counts {
+			if _, ok := cf.counts[pos]; !ok {
+				cf.points = append(cf.points, pos)
+			}
+			cf.counts[pos] += n
+		}
+		cf.entry += c.counts[fn.Blocks[0]]
+	}
+	for _, cf := range funcs {
+		sort.Slice(cf.points, func(i, j int) bool { return cf.points[i] < cf.points[j] })
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].Pos() < decls[j].Pos() })
+
+	var result []coverBlock
+	for _, decl := range decls {
+		if decl.Body == nil {
+			continue
+		}
+		v := &coverVisitor{c: c, pkg: pkgs[decl], funcs: funcs, fn: funcs[decl]}
+		ast.Inspect(decl.Body, v.loopHeader)
+		v.block(decl.Body, v.fn.entry)
+		result = append(result, v.blocks...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].file != result[j].file {
+			return result[i].file < result[j].file
+		}
+		return result[i].start.Offset < result[j].start.Offset
+	})
+	return result
+}
+
+// 遍历一个函数声明, 生成覆盖率统计的区间
+type coverVisitor struct {
+	c      *Coverage
+	pkg    string // 包路径
+	funcs  map[ast.Node]*coverFunc
+	fn     *coverFunc     // 当前的函数
+	skip   [][2]token.Pos // 循环的头部中每次迭代都执行的部分
+	blocks []coverBlock
+}
+
+// 记录循环的条件和后置语句, 以及range语句除了被遍历的表达式之外的部分
+func (v *coverVisitor) loopHeader(n ast.Node) bool {
+	switch s := n.(type) {
+	case *ast.ForStmt:
+		switch {
+		case s.Cond != nil:
+			v.skip = append(v.skip, [2]token.Pos{s.Cond.Pos(), s.Body.Lbrace})
+		case s.Post != nil:
+			v.skip = append(v.skip, [2]token.Pos{s.Post.Pos(), s.Body.Lbrace})
+		}
+	case *ast.RangeStmt:
+		v.skip = append(v.skip, [2]token.Pos{s.For, s.X.Pos()}, [2]token.Pos{s.X.End(), s.Body.Lbrace})
+	}
+	return true
+}
+
+// 语句块, switch和select的语句块按case划分
+func (v *coverVisitor) block(b *ast.BlockStmt, count int) {
+	if len(b.List) > 0 {
+		switch b.List[0].(type) {
+		case *ast.CaseClause:
+			for _, s := range b.List {
+				cc := s.(*ast.CaseClause)
+				for _, e := range cc.List {
+					v.funcLits(e)
+				}
+				v.stmtList(cc.Colon+1, cc.End(), cc.Body, false, count)
+			}
+			return
+		case *ast.CommClause:
+			for _, s := range b.List {
+				cc := s.(*ast.CommClause)
+				v.funcLits(cc.Comm)
+				v.stmtList(cc.Colon+1, cc.End(), cc.Body, false, count)
+			}
+			return
+		}
+	}
+	v.stmtList(b.Lbrace, b.Rbrace+1, b.List, true, count)
+}
+
+// 将语句列表划分为顺序执行的区间, 区间从pos开始, extend为true时最后一个区间延伸到end
+// count为外层区间的执行次数
+func (v *coverVisitor) stmtList(pos, end token.Pos, list []ast.Stmt, extend bool, count int) {
+	if len(list) == 0 {
+		v.add(pos, end, 0, count)
+		return
+	}
+	for len(list) > 0 {
+		blockEnd := end
+		n := 0
+		for n < len(list) {
+			s := list[n]
+			blockEnd = stmtBoundary(s)
+			n++
+			if endsSourceBlock(s) {
+				extend = false
+				break
+			}
+		}
+		if extend {
+			blockEnd = end
+		}
+		blockCount := v.add(pos, blockEnd, n, count)
+		for _, s := range list[:n] {
+			v.stmt(s, blockCount)
+		}
+		list = list[n:]
+		if len(list) > 0 {
+			pos = list[0].Pos()
+		}
+	}
+}
+
+// 记录区间[pos, end), 返回区间的执行次数
+func (v *coverVisitor) add(pos, end token.Pos, numStmts, count int) int {
+	if pos == end {
+		return count
+	}
+	if n, ok := v.count(pos, end); ok {
+		count = n
+	}
+	start, stop := v.c.fset.Position(pos), v.c.fset.Position(end)
+	v.blocks = append(v.blocks, coverBlock{
+		file:     start.Filename,
+		name:     path.Join(v.pkg, filepath.Base(start.Filename)),
+		start:    start,
+		end:      stop,
+		numStmts: numStmts,
+		count:    count,
+	})
+	return count
+}
+
+// 当前函数中位于区间[pos, end)的指令的最大执行次数, 区间中没有指令时ok为false
+func (v *coverVisitor) count(pos, end token.Pos) (n int, ok bool) {
+	points := v.fn.points
+	i := sort.Search(len(points), func(i int) bool { return points[i] >= pos })
+	for ; i < len(points) && points[i] < end; i++ {
+		if v.skipped(points[i]) {
+			continue
+		}
+		if c := v.fn.counts[points[i]]; !ok || c > n {
+			n, ok = c, true
+		}
+	}
+	return
+}
+
+func (v *coverVisitor) skipped(pos token.Pos) bool {
+	for _, r := range v.skip {
+		if r[0] <= pos && pos < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// 语句中嵌套的语句块和函数字面量
+func (v *coverVisitor) stmt(s ast.Stmt, count int) {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		v.block(s, count)
+	case *ast.IfStmt:
+		v.funcLits(s.Init)
+		v.funcLits(s.Cond)
+		v.block(s.Body, count)
+		switch e := s.Else.(type) {
+		case *ast.BlockStmt:
+			v.block(e, count)
+		case *ast.IfStmt:
+			// else if的条件单独作为一个区间
+			v.stmtList(e.Pos(), e.End(), []ast.Stmt{e}, false, count)
+		}
+	case *ast.ForStmt:
+		v.funcLits(s.Init)
+		v.funcLits(s.Cond)
+		v.funcLits(s.Post)
+		v.block(s.Body, count)
+	case *ast.RangeStmt:
+		v.funcLits(s.X)
+		v.block(s.Body, count)
+	case *ast.SwitchStmt:
+		v.funcLits(s.Init)
+		v.funcLits(s.Tag)
+		v.block(s.Body, count)
+	case *ast.TypeSwitchStmt:
+		v.funcLits(s.Init)
+		v.funcLits(s.Assign)
+		v.block(s.Body, count)
+	case *ast.SelectStmt:
+		v.block(s.Body, count)
+	case *ast.LabeledStmt:
+		v.stmt(s.Stmt, count)
+	default:
+		v.funcLits(s)
+	}
+}
+
+// 函数字面量的函数体按所在函数的执行次数统计
+func (v *coverVisitor) funcLits(n ast.Node) {
+	if n == nil {
+		return
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		lit, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		outer := v.fn
+		if fn := v.funcs[lit]; fn != nil {
+			v.fn = fn
+			v.block(lit.Body, fn.entry)
+		} else {
+			// 没有被构建的函数, 比如不可达的代码
+			v.fn = &coverFunc{}
+			v.block(lit.Body, 0)
+		}
+		v.fn = outer
+		return false
+	})
+}
+
+// 区间在语句中结束的位置: 控制流语句在语句体的'{'之前结束,
+// 包含函数字面量的语句在函数字面量之前结束
+func stmtBoundary(s ast.Stmt) token.Pos {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		return s.Lbrace
+	case *ast.IfStmt:
+		return funcLitPos(s.Body.Lbrace, s.Init, s.Cond)
+	case *ast.ForStmt:
+		return funcLitPos(s.Body.Lbrace, s.Init, s.Cond, s.Post)
+	case *ast.RangeStmt:
+		return funcLitPos(s.Body.Lbrace, s.X)
+	case *ast.SwitchStmt:
+		return funcLitPos(s.Body.Lbrace, s.Init, s.Tag)
+	case *ast.TypeSwitchStmt:
+		return funcLitPos(s.Body.Lbrace, s.Init, s.Assign)
+	case *ast.SelectStmt:
+		return s.Body.Lbrace
+	case *ast.LabeledStmt:
+		return stmtBoundary(s.Stmt)
+	}
+	return funcLitPos(s.End(), s)
+}
+
+// 语句是否结束一个顺序执行的区间
+func endsSourceBlock(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.BlockStmt, *ast.BranchStmt, *ast.ForStmt, *ast.IfStmt, *ast.RangeStmt,
+		*ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+		return true
+	case *ast.LabeledStmt:
+		// 可能是goto的目标
+		return true
+	case *ast.ExprStmt:
+		// panic改变控制流
+		if call, ok := s.X.(*ast.CallExpr); ok {
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "panic" && len(call.Args) == 1 {
+				return true
+			}
+		}
+	}
+	return funcLitPos(token.NoPos, s).IsValid()
+}
+
+// 节点中第一个函数字面量的位置, 没有时返回def
+func funcLitPos(def token.Pos, nodes ...ast.Node) token.Pos {
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		pos := token.NoPos
+		ast.Inspect(n, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.FuncLit); ok && !pos.IsValid() {
+				pos = lit.Pos()
+			}
+			return !pos.IsValid()
+		})
+		if pos.IsValid() {
+			return pos
+		}
+	}
+	return def
+}
+
+// 输出go tool cover格式的覆盖率文件, mode为set或count
+func (c *Coverage) WriteProfile(w io.Writer, mode string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", mode)
+	for _, cb := range c.profileBlocks() {
+		count := cb.count
+		if mode == "set" && count > 1 {
+			count = 1
+		}
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n",
+			cb.name, cb.start.Line, cb.start.Column, cb.end.Line, cb.end.Column,
+			cb.numStmts, count,
+		)
+	}
+	return bw.Flush()
+}
+
+// 输出带覆盖率标注的HTML页面, sources为文件名到源码的映射
+// 执行过的代码为绿色, 未执行的代码为红色
+func (c *Coverage) WriteHTML(w io.Writer, sources map[string]string) error {
+	blocks := c.profileBlocks()
+
+	var files []string
+	for name := range sources {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(coverHTMLHeader)
+	for _, name := range files {
+		src := sources[name]
+
+		// 每个字节的标记: 0表示不统计, 1表示未执行, 2表示已执行
+		marks := make([]byte, len(src))
+		for _, cb := range blocks {
+			if cb.file != name {
+				continue
+			}
+			mark := byte(1)
+			if cb.count > 0 {
+				mark = 2
+			}
+			for i := cb.start.Offset; i < cb.end.Offset && i < len(marks); i++ {
+				marks[i] = mark
+			}
+		}
+
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<pre>", html.EscapeString(name))
+		for i := 0; i < len(src); {
+			j := i
+			for j < len(src) && marks[j] == marks[i] {
+				j++
+			}
+			text := html.EscapeString(src[i:j])
+			switch marks[i] {
+			case 1:
+				fmt.Fprintf(bw, `<span class="cov0">%s</span>`, text)
+			case 2:
+				fmt.Fprintf(bw, `<span class="cov8">%s</span>`, text)
+			default:
+				bw.WriteString(text)
+			}
+			i = j
+		}
+		bw.WriteString("</pre>\n")
+	}
+	bw.WriteString(coverHTMLFooter)
+	return bw.Flush()
+}
+
+const coverHTMLHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { background: black; color: rgb(80, 80, 80); }
+pre { font-family: Menlo, monospace; font-weight: bold; }
+.cov0 { color: rgb(192, 0, 0); }
+.cov8 { color: rgb(44, 212, 149); }
+</style>
+</head>
+<body>
+`
+
+const coverHTMLFooter = `</body>
+</html>
+`
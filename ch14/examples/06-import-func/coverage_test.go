@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 区间按语句划分, 没有指令的语句(s := 0)也计入区间,
+// 循环的条件和后置语句不影响循环之前的区间的次数
+func TestCoverageProfile(t *testing.T) {
+	const src = `package main
+
+func sum(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x == 0 {
+		return 0
+	}
+	return 1
+}
+
+func main() {
+	f := func(x int) int {
+		return x * 2
+	}
+	println(sum(10), f(3))
+	for i := 0; i < 3; i++ {
+		sign(i)
+	}
+	switch {
+	case sum(2) == 1:
+		println("one")
+	default:
+		println("other")
+	}
+}
+`
+	const want = `mode: count
+command-line-arguments/test.go:3.21,5.25 2 2
+command-line-arguments/test.go:5.25,7.3 1 12
+command-line-arguments/test.go:8.2,8.10 1 2
+command-line-arguments/test.go:11.22,12.11 1 3
+command-line-arguments/test.go:12.11,14.3 1 0
+command-line-arguments/test.go:14.9,14.19 1 3
+command-line-arguments/test.go:14.19,16.3 1 1
+command-line-arguments/test.go:17.2,17.10 1 2
+command-line-arguments/test.go:20.13,21.7 1 1
+command-line-arguments/test.go:21.23,23.3 1 1
+command-line-arguments/test.go:24.2,25.25 2 1
+command-line-arguments/test.go:25.25,27.3 1 3
+command-line-arguments/test.go:28.2,28.9 1 1
+command-line-arguments/test.go:29.19,30.17 1 1
+command-line-arguments/test.go:31.10,32.19 1 0
+`
+	p := NewEngine(buildPackage(t, src), nil)
+	cover := p.EnableCoverage()
+	if _, code := runEngine(t, p); code != 0 {
+		t.Fatalf("退出码: %d", code)
+	}
+
+	var buf bytes.Buffer
+	if err := cover.WriteProfile(&buf, "count"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("覆盖率文件:\n%s期望:\n%s", got, want)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"log"
 	"os"
 
@@ -22,6 +24,11 @@ func main() {
 }
 `
 
+var (
+	flagCoverProfile = flag.String("coverprofile", "", "write a coverage profile to file")
+	flagCoverHTML    = flag.String("coverhtml", "", "write an annotated HTML coverage view to file")
+)
+
 func my_print(args ...watypes.Value) watypes.Value {
 	fmt.Print("my_print: ")
 	for _, a := range args {
@@ -38,6 +45,8 @@ func my_print(args ...watypes.Value) watypes.Value {
 }
 
 func main() {
+	flag.Parse()
+
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, "test.go", src, parser.AllErrors)
 	if err != nil {
@@ -51,15 +60,18 @@ func main() {
 		Implicits:  make(map[ast.Node]types.Object),
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
 	}
 
+	// 和go命令处理命令行中的源文件一样, 包路径为command-line-arguments
+	// 覆盖率文件中的文件名为command-line-arguments/test.go
 	conf := types.Config{Importer: nil}
-	pkg, err := conf.Check("test.go", fset, []*ast.File{f}, info)
+	pkg, err := conf.Check("command-line-arguments", fset, []*ast.File{f}, info)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions)
+	var ssaProg = ssa.NewProgram(fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
 	var ssaPkg = ssaProg.CreatePackage(pkg, []*ast.File{f}, info, true)
 
 	ssaPkg.Build()
@@ -69,7 +81,38 @@ func main() {
 	user_funcs["my_print"] = my_print
 
 	p := NewEngine(ssaPkg, user_funcs)
-	p.initGlobals()
 
-	p.runFunc(ssaPkg.Func("main"), nil)
+	var cover *Coverage
+	if *flagCoverProfile != "" || *flagCoverHTML != "" {
+		cover = p.EnableCoverage()
+	}
+
+	code := p.RunMain()
+
+	if *flagCoverProfile != "" {
+		writeCoverFile(*flagCoverProfile, func(w io.Writer) error {
+			return cover.WriteProfile(w, "count")
+		})
+	}
+	if *flagCoverHTML != "" {
+		writeCoverFile(*flagCoverHTML, func(w io.Writer) error {
+			return cover.WriteHTML(w, map[string]string{"test.go": src})
+		})
+	}
+
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+func writeCoverFile(filename string, write func(w io.Writer) error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		log.Fatal(err)
+	}
 }
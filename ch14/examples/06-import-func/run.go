@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"go/types"
+	"os"
 	"sync"
 
 	"github.com/wa-lang/ssago/06-import-func/wabuildin"
@@ -21,6 +23,9 @@ type Engine struct {
 
 	// 外部导入的函数
 	externals map[string]UserFunc
+
+	// 覆盖率统计, nil表示不统计
+	coverage *Coverage
 }
 
 func NewEngine(mainpkg *ssa.Package, funcs map[string]UserFunc) *Engine {
@@ -36,6 +41,14 @@ func NewEngine(mainpkg *ssa.Package, funcs map[string]UserFunc) *Engine {
 	return p
 }
 
+// 打开覆盖率统计, 返回的Coverage在程序执行后输出结果
+func (p *Engine) EnableCoverage() *Coverage {
+	if p.coverage == nil {
+		p.coverage = NewCoverage(p.main.Prog)
+	}
+	return p.coverage
+}
+
 // 读全局变量
 func (p *Engine) getGlobal(key *ssa.Global) (v *watypes.Value, ok bool) {
 	v, ok = p.globals[key.RelString(nil)]
@@ -63,6 +76,25 @@ func (p *Engine) initGlobals() *Engine {
 	return p
 }
 
+// 执行main函数, 返回进程退出码
+// 凹语言程序中的运行时错误(比如整数除零)按照Go的格式输出panic信息, 退出码为2
+func (p *Engine) RunMain() (exitCode int) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(watypes.Error)
+			if !ok {
+				panic(r) // 解释器自身的错误
+			}
+			fmt.Fprintf(os.Stderr, "panic: %s\n\ngoroutine 1 [running]:\n", e.Error())
+			exitCode = 2
+		}
+	}()
+
+	p.initGlobals()
+	p.runFunc(p.main.Func("main"), nil)
+	return 0
+}
+
 type Frame struct {
 	//局部变量、虚拟寄存器等：
 	env map[ssa.Value]watypes.Value
@@ -93,6 +125,9 @@ func (p *Engine) getValue(fr *Frame, key ssa.Value) watypes.Value {
 		}
 	case *ssa.Const:
 		return waops.ConstValue(key)
+	case *ssa.Function:
+		// 函数作为值使用, 比如赋值给变量或者和nil比较
+		return key
 	case nil:
 		return nil
 	}
@@ -105,10 +140,6 @@ func (p *Engine) getValue(fr *Frame, key ssa.Value) watypes.Value {
 }
 
 func (p *Engine) runFunc(fn watypes.Value, args []watypes.Value) watypes.Value {
-	if fn, ok := fn.(*ssa.Builtin); ok {
-		return callBuiltin(fn, args)
-	}
-
 	if fn, ok := fn.(*ssa.Function); ok {
 		if ext := p.externals[fn.Name()]; ext != nil {
 			return ext(args)
@@ -132,6 +163,10 @@ func (p *Engine) runFunc(fn watypes.Value, args []watypes.Value) watypes.Value {
 }
 
 func (p *Engine) runFrame(fr *Frame) {
+	if p.coverage != nil {
+		p.coverage.hit(fr.block)
+	}
+
 	for i := 0; i < len(fr.block.Instrs); i++ {
 		switch ins := fr.block.Instrs[i].(type) {
 		case *ssa.Store:
@@ -145,7 +180,51 @@ func (p *Engine) runFrame(fr *Frame) {
 
 		case *ssa.Call:
 			args := p.prepareCall(fr, &ins.Call)
-			fr.env[ins] = p.runFunc(ins.Call.Value, args)
+			if fn, ok := ins.Call.Value.(*ssa.Builtin); ok {
+				fr.env[ins] = callBuiltin(fn, args, ins.Call.Args)
+			} else {
+				fr.env[ins] = p.runFunc(p.getValue(fr, ins.Call.Value), args)
+			}
+
+		case *ssa.Alloc:
+			cell := waops.Zero(waops.Deref(ins.Type()))
+			fr.env[ins] = &cell
+
+		case *ssa.FieldAddr:
+			fr.env[ins] = waops.FieldAddr(p.getValue(fr, ins.X), ins.Field)
+
+		case *ssa.Field:
+			fr.env[ins] = waops.Field(p.getValue(fr, ins.X), ins.Field)
+
+		case *ssa.IndexAddr:
+			fr.env[ins] = waops.IndexAddr(p.getValue(fr, ins.X), p.getValue(fr, ins.Index))
+
+		case *ssa.Index:
+			fr.env[ins] = waops.Index(p.getValue(fr, ins.X), p.getValue(fr, ins.Index))
+
+		case *ssa.Lookup:
+			fr.env[ins] = waops.Lookup(ins.X.Type(), p.getValue(fr, ins.X), p.getValue(fr, ins.Index), ins.CommaOk)
+
+		case *ssa.Extract:
+			fr.env[ins] = p.getValue(fr, ins.Tuple).(watypes.Tuple)[ins.Index]
+
+		case *ssa.MapUpdate:
+			waops.MapUpdate(p.getValue(fr, ins.Map), p.getValue(fr, ins.Key), p.getValue(fr, ins.Value))
+
+		case *ssa.MakeInterface:
+			fr.env[ins] = waops.MakeInterface(ins.X.Type(), p.getValue(fr, ins.X))
+
+		case *ssa.MakeSlice:
+			fr.env[ins] = waops.MakeSlice(ins.Type().Underlying().(*types.Slice).Elem(), p.getValue(fr, ins.Len), p.getValue(fr, ins.Cap))
+
+		case *ssa.MakeMap:
+			fr.env[ins] = waops.MakeMap(ins.Type().Underlying().(*types.Map).Key())
+
+		case *ssa.Slice:
+			fr.env[ins] = waops.Slice(p.getValue(fr, ins.X), p.getValue(fr, ins.Low), p.getValue(fr, ins.High), p.getValue(fr, ins.Max))
+
+		case *ssa.ChangeType:
+			fr.env[ins] = p.getValue(fr, ins.X)
 
 		case *ssa.Return:
 			switch len(ins.Results) {
@@ -201,10 +280,38 @@ func (p *Engine) prepareCall(fr *Frame, call *ssa.CallCommon) (args []watypes.Va
 	return
 }
 
-func callBuiltin(fn *ssa.Builtin, args []watypes.Value) watypes.Value {
+func callBuiltin(fn *ssa.Builtin, args []watypes.Value, params []ssa.Value) watypes.Value {
 	switch fn.Name() {
 	case "print", "println": // print(any, ...)
-		return wabuiltin.Print(fn, args)
+		argTypes := make([]types.Type, len(params))
+		for i, arg := range params {
+			argTypes[i] = arg.Type()
+		}
+		return wabuiltin.Print(fn, args, argTypes)
+
+	case "len": // len(string|slice|array|*array|map)
+		switch x := args[0].(type) {
+		case string:
+			return len(x)
+		case watypes.Slice:
+			return len(x)
+		case watypes.Array:
+			return len(x)
+		case *watypes.Value:
+			return len((*x).(watypes.Array))
+		case *watypes.Map:
+			return x.Len()
+		}
+
+	case "cap": // cap(slice|array|*array)
+		switch x := args[0].(type) {
+		case watypes.Slice:
+			return cap(x)
+		case watypes.Array:
+			return len(x)
+		case *watypes.Value:
+			return len((*x).(watypes.Array))
+		}
 	}
 
 	panic("unknown built-in: " + fn.Name())
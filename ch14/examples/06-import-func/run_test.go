@@ -0,0 +1,128 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// 构建src对应的main包
+func buildPackage(t *testing.T, src string) *ssa.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.go", src, parser.AllErrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
+	}
+	pkg, err := new(types.Config).Check("command-line-arguments", fset, []*ast.File{f}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := ssa.NewProgram(fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
+	ssaPkg := prog.CreatePackage(pkg, []*ast.File{f}, info, true)
+	ssaPkg.Build()
+	return ssaPkg
+}
+
+// 执行程序, 返回标准输出和标准错误的内容以及退出码
+func runEngine(t *testing.T, p *Engine) (string, int) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code := p.RunMain()
+	w.Close()
+	return <-out, code
+}
+
+func runProgram(t *testing.T, src string) (string, int) {
+	t.Helper()
+	return runEngine(t, NewEngine(buildPackage(t, src), nil))
+}
+
+func TestRunPrograms(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want string
+		code int
+	}{
+		{
+			name: "map-key",
+			src: `package main
+
+type point struct{ x, y int }
+
+func main() {
+	m := map[point]int{}
+	m[point{1, 2}] = 3
+	m[point{1, 2}] += 4
+	var k any = point{1, 2}
+	n := map[any]string{k: "a", 1.5: "b"}
+	println(len(m), m[point{1, 2}], n[point{1, 2}], n[1.5])
+}
+`,
+			want: "1 7 a b\n",
+		},
+		{
+			name: "uncomparable",
+			src: `package main
+
+func main() {
+	var x, y any = []int{1}, []int{1}
+	println(x == y)
+}
+`,
+			want: "panic: runtime error: comparing uncomparable type []int\n\ngoroutine 1 [running]:\n",
+			code: 2,
+		},
+		{
+			name: "func-value",
+			src: `package main
+
+func f(x int) int { return x + 1 }
+
+func main() {
+	fn := f
+	var g func(int) int
+	println(fn != nil, g == nil, fn(2))
+	g = fn
+	println(g(5))
+}
+`,
+			want: "true true 3\n6\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, code := runProgram(t, tt.src)
+			if got != tt.want || code != tt.code {
+				t.Errorf("输出:\n%s退出码: %d\n期望:\n%s退出码: %d", got, code, tt.want, tt.code)
+			}
+		})
+	}
+}
@@ -4,13 +4,14 @@ package wabuiltin
 
 import (
 	"bytes"
+	"go/types"
 	"os"
 
 	"github.com/wa-lang/ssago/06-import-func/watypes"
 	"golang.org/x/tools/go/ssa"
 )
 
-func Print(fn *ssa.Builtin, args []watypes.Value) ssa.Value {
+func Print(fn *ssa.Builtin, args []watypes.Value, argTypes []types.Type) ssa.Value {
 	ln := fn.Name() == "println"
 	var buf bytes.Buffer
 
@@ -18,7 +19,7 @@ func Print(fn *ssa.Builtin, args []watypes.Value) ssa.Value {
 		if i > 0 && ln {
 			buf.WriteRune(' ')
 		}
-		buf.WriteString(watypes.ToString(arg))
+		buf.WriteString(watypes.PrintString(argTypes[i], arg))
 	}
 	if ln {
 		buf.WriteRune('\n')
@@ -127,6 +127,9 @@ func binop(op token.Token, t types.Type, x, y watypes.Value) watypes.Value {
 		}
 
 	case token.QUO:
+		if isIntZero(y) {
+			panic(watypes.RuntimeError("integer divide by zero"))
+		}
 		switch x.(type) {
 		case int:
 			return x.(int) / y.(int)
@@ -161,6 +164,9 @@ func binop(op token.Token, t types.Type, x, y watypes.Value) watypes.Value {
 		}
 
 	case token.REM:
+		if isIntZero(y) {
+			panic(watypes.RuntimeError("integer divide by zero"))
+		}
 		switch x.(type) {
 		case int:
 			return x.(int) % y.(int)
@@ -290,6 +296,60 @@ func binop(op token.Token, t types.Type, x, y watypes.Value) watypes.Value {
 			return x.(uintptr) &^ y.(uintptr)
 		}
 
+	case token.SHL:
+		s := shiftCount(y)
+		switch x.(type) {
+		case int:
+			return x.(int) << s
+		case int8:
+			return x.(int8) << s
+		case int16:
+			return x.(int16) << s
+		case int32:
+			return x.(int32) << s
+		case int64:
+			return x.(int64) << s
+		case uint:
+			return x.(uint) << s
+		case uint8:
+			return x.(uint8) << s
+		case uint16:
+			return x.(uint16) << s
+		case uint32:
+			return x.(uint32) << s
+		case uint64:
+			return x.(uint64) << s
+		case uintptr:
+			return x.(uintptr) << s
+		}
+
+	case token.SHR:
+		s := shiftCount(y)
+		switch x.(type) {
+		case int:
+			return x.(int) >> s
+		case int8:
+			return x.(int8) >> s
+		case int16:
+			return x.(int16) >> s
+		case int32:
+			return x.(int32) >> s
+		case int64:
+			return x.(int64) >> s
+		case uint:
+			return x.(uint) >> s
+		case uint8:
+			return x.(uint8) >> s
+		case uint16:
+			return x.(uint16) >> s
+		case uint32:
+			return x.(uint32) >> s
+		case uint64:
+			return x.(uint64) >> s
+		case uintptr:
+			return x.(uintptr) >> s
+		}
+
 	case token.LSS:
 		switch x.(type) {
 		case int:
@@ -420,3 +480,77 @@ func binop(op token.Token, t types.Type, x, y watypes.Value) watypes.Value {
 	}
 	panic(fmt.Sprintf("invalid binary op: %T %s %T", x, op, y))
 }
+
+// 判断整数类型的除数是否为零(浮点数和复数除零不会panic)
+func isIntZero(y watypes.Value) bool {
+	switch y := y.(type) {
+	case int:
+		return y == 0
+	case int8:
+		return y == 0
+	case int16:
+		return y == 0
+	case int32:
+		return y == 0
+	case int64:
+		return y == 0
+	case uint:
+		return y == 0
+	case uint8:
+		return y == 0
+	case uint16:
+		return y == 0
+	case uint32:
+		return y == 0
+	case uint64:
+		return y == 0
+	case uintptr:
+		return y == 0
+	}
+	return false
+}
+
+// 移位运算的右操作数可以是任意整数类型, 和左操作数的类型无关.
+// 有符号的负数移位量会触发运行时panic, 超过位宽的移位量由Go的移位规则处理.
+func shiftCount(y watypes.Value) uint64 {
+	switch y := y.(type) {
+	case int:
+		if y < 0 {
+			panic(watypes.RuntimeError("negative shift amount"))
+		}
+		return uint64(y)
+	case int8:
+		if y < 0 {
+			panic(watypes.RuntimeError("negative shift amount"))
+		}
+		return uint64(y)
+	case int16:
+		if y < 0 {
+			panic(watypes.RuntimeError("negative shift amount"))
+		}
+		return uint64(y)
+	case int32:
+		if y < 0 {
+			panic(watypes.RuntimeError("negative shift amount"))
+		}
+		return uint64(y)
+	case int64:
+		if y < 0 {
+			panic(watypes.RuntimeError("negative shift amount"))
+		}
+		return uint64(y)
+	case uint:
+		return uint64(y)
+	case uint8:
+		return uint64(y)
+	case uint16:
+		return uint64(y)
+	case uint32:
+		return uint64(y)
+	case uint64:
+		return y
+	case uintptr:
+		return uint64(y)
+	}
+	panic(fmt.Sprintf("invalid shift count: %T", y))
+}
@@ -0,0 +1,144 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+package waops
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/wa-lang/ssago/06-import-func/watypes"
+)
+
+type binOpCase struct {
+	op    token.Token
+	x, y  watypes.Value
+	want  watypes.Value // 期望的结果
+	panic string        // 期望的运行时错误, 为空表示不会panic
+}
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// 整数类型T的测试用例, 期望的结果由Go的运算得到
+func intCases[T integer](bits uint) []binOpCase {
+	var zero T
+	one := T(1)
+	seven := T(7)
+	allOnes := ^zero // 有符号类型为-1, 无符号类型为最大值
+	x := T(0x5a)
+
+	cases := []binOpCase{
+		// 移位量可以是任意整数类型
+		{op: token.SHL, x: x, y: uint8(3), want: x << 3},
+		{op: token.SHL, x: x, y: int(3), want: x << 3},
+		{op: token.SHL, x: x, y: int64(bits - 1), want: x << (bits - 1)},
+		{op: token.SHL, x: one, y: uintptr(bits - 1), want: one << (bits - 1)},
+
+		// 移位量不小于位宽
+		{op: token.SHL, x: x, y: uint(bits), want: zero},
+		{op: token.SHL, x: x, y: uint64(math.MaxUint64), want: zero},
+		{op: token.SHR, x: x, y: int16(bits), want: zero},
+		{op: token.SHR, x: allOnes, y: uint32(bits), want: allOnes >> bits},
+		{op: token.SHR, x: allOnes, y: int8(1), want: allOnes >> 1},
+
+		// 有符号的负数移位量
+		{op: token.SHL, x: x, y: int(-1), panic: "runtime error: negative shift amount"},
+		{op: token.SHR, x: x, y: int8(-1), panic: "runtime error: negative shift amount"},
+		{op: token.SHR, x: x, y: int64(math.MinInt64), panic: "runtime error: negative shift amount"},
+
+		// 除法和取余向零截断
+		{op: token.QUO, x: seven, y: T(2), want: seven / 2},
+		{op: token.REM, x: seven, y: T(2), want: seven % 2},
+		{op: token.QUO, x: zero - seven, y: T(2), want: (zero - seven) / 2},
+		{op: token.REM, x: zero - seven, y: T(2), want: (zero - seven) % 2},
+		{op: token.QUO, x: seven, y: zero, panic: "runtime error: integer divide by zero"},
+		{op: token.REM, x: seven, y: zero, panic: "runtime error: integer divide by zero"},
+
+		{op: token.AND_NOT, x: x, y: T(0x0f), want: x &^ 0x0f},
+		{op: token.AND_NOT, x: allOnes, y: x, want: allOnes &^ x},
+		{op: token.AND_NOT, x: x, y: zero, want: x},
+	}
+
+	// 有符号类型的最小值除以-1不溢出: 商为最小值, 余数为0
+	if allOnes < 0 {
+		min := one << (bits - 1)
+		cases = append(cases,
+			binOpCase{op: token.QUO, x: min, y: allOnes, want: min},
+			binOpCase{op: token.REM, x: min, y: allOnes, want: zero},
+			binOpCase{op: token.SHR, x: min, y: uint(bits - 1), want: allOnes},
+		)
+	}
+	return cases
+}
+
+func TestBinOpIntegers(t *testing.T) {
+	tests := []struct {
+		typ   types.Type
+		cases []binOpCase
+	}{
+		{types.Typ[types.Int], intCases[int](strconv.IntSize)},
+		{types.Typ[types.Int8], intCases[int8](8)},
+		{types.Typ[types.Int16], intCases[int16](16)},
+		{types.Typ[types.Int32], intCases[int32](32)},
+		{types.Typ[types.Int64], intCases[int64](64)},
+		{types.Typ[types.Uint], intCases[uint](strconv.IntSize)},
+		{types.Typ[types.Uint8], intCases[uint8](8)},
+		{types.Typ[types.Uint16], intCases[uint16](16)},
+		{types.Typ[types.Uint32], intCases[uint32](32)},
+		{types.Typ[types.Uint64], intCases[uint64](64)},
+		{types.Typ[types.Uintptr], intCases[uintptr](strconv.IntSize)},
+	}
+	for _, tt := range tests {
+		for _, c := range tt.cases {
+			name := fmt.Sprintf("%s(%v)%s%T(%v)", tt.typ, c.x, c.op, c.y, c.y)
+			t.Run(name, func(t *testing.T) {
+				testBinOp(t, tt.typ, c)
+			})
+		}
+	}
+}
+
+// 浮点数和复数除以0不会panic
+func TestBinOpFloatDivZero(t *testing.T) {
+	tests := []struct {
+		typ types.Type
+		c   binOpCase
+	}{
+		{types.Typ[types.Float64], binOpCase{op: token.QUO, x: 1.0, y: 0.0, want: math.Inf(1)}},
+		{types.Typ[types.Float32], binOpCase{op: token.QUO, x: float32(-1), y: float32(0), want: float32(math.Inf(-1))}},
+	}
+	for _, tt := range tests {
+		testBinOp(t, tt.typ, tt.c)
+	}
+}
+
+func testBinOp(t *testing.T, typ types.Type, c binOpCase) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err, ok := r.(watypes.RuntimeError)
+		if !ok {
+			t.Fatalf("%v %s %v: unexpected panic %v", c.x, c.op, c.y, r)
+		}
+		if c.panic == "" || err.Error() != c.panic {
+			t.Fatalf("%v %s %v: panic %q, want %q", c.x, c.op, c.y, err.Error(), c.panic)
+		}
+	}()
+
+	got := BinOp(c.op, typ, c.x, c.y)
+	if c.panic != "" {
+		t.Fatalf("%v %s %v = %v, want panic %q", c.x, c.op, c.y, got, c.panic)
+	}
+	if got != c.want {
+		t.Fatalf("%v %s %v = %T(%v), want %T(%v)", c.x, c.op, c.y, got, got, c.want, c.want)
+	}
+}
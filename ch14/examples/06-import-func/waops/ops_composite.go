@@ -0,0 +1,160 @@
+// 版权 @2019 凹语言 作者。保留所有权利。
+
+package waops
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/wa-lang/ssago/06-import-func/watypes"
+)
+
+// 取结构体指针x的第field个字段的地址
+func FieldAddr(x watypes.Value, field int) watypes.Value {
+	ptr := x.(*watypes.Value)
+	if ptr == nil {
+		panic(watypes.RuntimeError("invalid memory address or nil pointer dereference"))
+	}
+	return &(*ptr).(watypes.Struct)[field]
+}
+
+// 读取结构体x的第field个字段
+func Field(x watypes.Value, field int) watypes.Value {
+	return watypes.Copy(x.(watypes.Struct)[field])
+}
+
+// 取切片或数组指针x的第index个元素的地址
+func IndexAddr(x, index watypes.Value) watypes.Value {
+	var elems []watypes.Value
+	switch x := x.(type) {
+	case watypes.Slice:
+		elems = x
+	case *watypes.Value: // *array
+		if x == nil {
+			panic(watypes.RuntimeError("invalid memory address or nil pointer dereference"))
+		}
+		elems = (*x).(watypes.Array)
+	default:
+		panic(fmt.Sprintf("unexpected x type in IndexAddr: %T", x))
+	}
+	i := checkIndex(index, len(elems))
+	return &elems[i]
+}
+
+// 读取数组x的第index个元素
+func Index(x, index watypes.Value) watypes.Value {
+	a := x.(watypes.Array)
+	return watypes.Copy(a[checkIndex(index, len(a))])
+}
+
+// 读取字符串的字节或map的元素, 类型t是x的类型
+func Lookup(t types.Type, x, index watypes.Value, commaOk bool) watypes.Value {
+	switch x := x.(type) {
+	case string:
+		return x[checkIndex(index, len(x))]
+
+	case *watypes.Map:
+		v, ok := x.Lookup(index)
+		if !ok {
+			v = zero(t.Underlying().(*types.Map).Elem())
+		}
+		if commaOk {
+			return watypes.Tuple{watypes.Copy(v), ok}
+		}
+		return watypes.Copy(v)
+	}
+	panic(fmt.Sprintf("unexpected x type in Lookup: %T", x))
+}
+
+// 更新map的元素
+func MapUpdate(m, key, v watypes.Value) {
+	x := m.(*watypes.Map)
+	if x == nil {
+		panic(watypes.PlainError("assignment to entry in nil map"))
+	}
+	x.Update(key, watypes.Copy(v))
+}
+
+// 将类型为t的值x装箱为接口
+func MakeInterface(t types.Type, x watypes.Value) watypes.Value {
+	return watypes.Iface{T: t, V: watypes.Copy(x)}
+}
+
+// 创建元素类型为elem的切片
+func MakeSlice(elem types.Type, length, capacity watypes.Value) watypes.Value {
+	n, c := toInt(length), toInt(capacity)
+	if n < 0 {
+		panic(watypes.RuntimeError("makeslice: len out of range"))
+	}
+	if c < n {
+		panic(watypes.RuntimeError("makeslice: cap out of range"))
+	}
+	s := make(watypes.Slice, n, c)
+	for i := range s[:c] {
+		s[:c][i] = zero(elem)
+	}
+	return s
+}
+
+// 创建键类型为key的map
+func MakeMap(key types.Type) watypes.Value {
+	return watypes.NewMap(key)
+}
+
+// 对切片/字符串/数组指针x做切片运算, low/high/max为nil时使用默认值
+func Slice(x, low, high, max watypes.Value) watypes.Value {
+	var n, c int
+	switch x := x.(type) {
+	case string:
+		n, c = len(x), len(x)
+	case watypes.Slice:
+		n, c = len(x), cap(x)
+	case *watypes.Value: // *array
+		if x == nil {
+			panic(watypes.RuntimeError("invalid memory address or nil pointer dereference"))
+		}
+		n, c = len((*x).(watypes.Array)), len((*x).(watypes.Array))
+	}
+
+	l, h, m := 0, n, c
+	if low != nil {
+		l = toInt(low)
+	}
+	if high != nil {
+		h = toInt(high)
+	}
+	if max != nil {
+		m = toInt(max)
+	}
+	if _, ok := x.(string); ok {
+		if h < 0 || h > n {
+			panic(watypes.RuntimeError(fmt.Sprintf("slice bounds out of range [:%d] with length %d", h, n)))
+		}
+	} else if m < 0 || m > c {
+		panic(watypes.RuntimeError(fmt.Sprintf("slice bounds out of range [::%d] with capacity %d", m, c)))
+	} else if h < 0 || h > m {
+		panic(watypes.RuntimeError(fmt.Sprintf("slice bounds out of range [:%d:%d]", h, m)))
+	}
+	if l < 0 || l > h {
+		panic(watypes.RuntimeError(fmt.Sprintf("slice bounds out of range [%d:%d]", l, h)))
+	}
+
+	switch x := x.(type) {
+	case string:
+		return x[l:h]
+	case watypes.Slice:
+		return x[l:h:m]
+	case *watypes.Value:
+		return watypes.Slice((*x).(watypes.Array))[l:h:m]
+	}
+	panic(fmt.Sprintf("unexpected x type in Slice: %T", x))
+}
+
+// 检查下标是否越界, 返回int类型的下标
+func checkIndex(index watypes.Value, length int) int {
+	i := toInt(index)
+	if i < 0 || i >= length {
+		panic(watypes.RuntimeError(fmt.Sprintf("index out of range [%d] with length %d", i, length)))
+	}
+	return i
+}
@@ -17,8 +17,9 @@ func ConstValue(c *ssa.Const) watypes.Value {
 }
 
 func constValue(c *ssa.Const) watypes.Value {
-	if c.IsNil() {
-		return Zero(c.Type()) // typed nil
+	// typed nil, 以及结构体/数组等聚合类型的零值常量(比如Pt{})
+	if c.Value == nil {
+		return Zero(c.Type())
 	}
 
 	if t, ok := c.Type().Underlying().(*types.Basic); ok {
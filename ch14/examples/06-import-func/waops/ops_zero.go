@@ -8,6 +8,7 @@ import (
 	"unsafe"
 
 	"github.com/wa-lang/ssago/06-import-func/watypes"
+	"golang.org/x/tools/go/ssa"
 )
 
 // 生成零值
@@ -66,6 +67,33 @@ func zero(t types.Type) watypes.Value {
 		}
 	case *types.Pointer:
 		return (*watypes.Value)(nil)
+	case *types.Named:
+		return zero(t.Underlying())
+	case *types.Alias:
+		return zero(types.Unalias(t))
+	case *types.TypeParam:
+		// 以InstantiateGenerics模式构建SSA时, 只会执行实例化之后的函数体
+		panic(fmt.Sprint("zero: uninstantiated type parameter ", t))
+	case *types.Struct:
+		s := make(watypes.Struct, t.NumFields())
+		for i := range s {
+			s[i] = zero(t.Field(i).Type())
+		}
+		return s
+	case *types.Array:
+		a := make(watypes.Array, t.Len())
+		for i := range a {
+			a[i] = zero(t.Elem())
+		}
+		return a
+	case *types.Slice:
+		return watypes.Slice(nil)
+	case *types.Map:
+		return (*watypes.Map)(nil)
+	case *types.Interface:
+		return watypes.Iface{}
+	case *types.Signature:
+		return (*ssa.Function)(nil)
 	}
 	panic(fmt.Sprint("zero: unexpected ", t))
 }
@@ -3,7 +3,10 @@
 package waops
 
 import (
+	"fmt"
 	"go/types"
+
+	"github.com/wa-lang/ssago/06-import-func/watypes"
 )
 
 // 对于指针获取指针指向的类型, 或者返回当前类型.
@@ -13,3 +16,32 @@ func Deref(typ types.Type) types.Type {
 	}
 	return typ
 }
+
+// 将任意整数类型的值转为int, 用于下标和长度
+func toInt(v watypes.Value) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	case uint64:
+		return int(v)
+	case uintptr:
+		return int(v)
+	}
+	panic(fmt.Sprintf("cannot convert %T to int", v))
+}
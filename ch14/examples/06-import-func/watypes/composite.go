@@ -0,0 +1,126 @@
+package watypes
+
+import (
+	"go/types"
+)
+
+// 结构体的值, 按字段的顺序保存
+type Struct []Value
+
+// 数组的值
+type Array []Value
+
+// 切片的值, 第i个元素的地址即&s[i]
+type Slice []Value
+
+// 接口的值, 包含动态类型和动态值
+// T为nil时表示nil接口
+type Iface struct {
+	T types.Type
+	V Value
+}
+
+// map的值, nil指针对应nil map
+// 键通过Hash分桶, 桶内用Equals比较
+type Map struct {
+	KeyType types.Type
+	buckets map[uint64][]mapEntry
+	length  int
+}
+
+type mapEntry struct {
+	key   Value
+	value Value
+}
+
+// 创建键类型为keyType的map
+func NewMap(keyType types.Type) *Map {
+	return &Map{KeyType: keyType, buckets: make(map[uint64][]mapEntry)}
+}
+
+// map的元素个数
+func (m *Map) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.length
+}
+
+// 查找key对应的值
+func (m *Map) Lookup(key Value) (v Value, ok bool) {
+	if m == nil {
+		return nil, false
+	}
+	for _, e := range m.buckets[Hash(m.KeyType, key)] {
+		if Equals(m.KeyType, e.key, key) {
+			return e.value, true
+		}
+	}
+	return nil, false
+}
+
+// 插入或更新key对应的值
+func (m *Map) Update(key, v Value) {
+	h := Hash(m.KeyType, key)
+	bucket := m.buckets[h]
+	for i, e := range bucket {
+		if Equals(m.KeyType, e.key, key) {
+			bucket[i].value = v
+			return
+		}
+	}
+	m.buckets[h] = append(bucket, mapEntry{key: key, value: v})
+	m.length++
+}
+
+// 删除key对应的值
+func (m *Map) Delete(key Value) {
+	if m == nil {
+		return
+	}
+	h := Hash(m.KeyType, key)
+	bucket := m.buckets[h]
+	for i, e := range bucket {
+		if Equals(m.KeyType, e.key, key) {
+			m.buckets[h] = append(bucket[:i:i], bucket[i+1:]...)
+			m.length--
+			return
+		}
+	}
+}
+
+// 遍历map, fn返回false时停止; 和Go一样遍历顺序是不确定的
+func (m *Map) Range(fn func(k, v Value) bool) {
+	if m == nil {
+		return
+	}
+	for _, bucket := range m.buckets {
+		for _, e := range bucket {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// 复制值语义的类型(结构体和数组), 其它类型直接返回
+func Copy(v Value) Value {
+	switch v := v.(type) {
+	case Struct:
+		c := make(Struct, len(v))
+		for i, e := range v {
+			c[i] = Copy(e)
+		}
+		return c
+	case Array:
+		c := make(Array, len(v))
+		for i, e := range v {
+			c[i] = Copy(e)
+		}
+		return c
+	}
+	return v
+}
+
+// 多返回值或者comma-ok形式的结果
+type Tuple []Value
@@ -0,0 +1,26 @@
+package watypes
+
+// 解释器报告的运行时错误都实现这个接口, 对应Go语言的runtime.Error接口
+type Error interface {
+	error
+	RuntimeError()
+}
+
+// 运行时错误, 对应Go语言的runtime.Error
+// 解释器遇到非法操作(比如整数除零)时以该类型panic, 由Engine转为凹语言程序的panic
+type RuntimeError string
+
+func (e RuntimeError) RuntimeError() {}
+
+func (e RuntimeError) Error() string {
+	return "runtime error: " + string(e)
+}
+
+// 不带"runtime error: "前缀的运行时错误, 比如向nil map赋值
+type PlainError string
+
+func (e PlainError) RuntimeError() {}
+
+func (e PlainError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,372 @@
+package watypes
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// PrintString 按照gc中内置函数print/println的规则输出类型为t的值v
+//
+// 指针/map/chan/函数输出地址, 切片输出[len/cap]地址, 接口输出(类型地址,数据地址).
+// gc不支持打印结构体和数组, 这里按照fmt的%v格式输出.
+func PrintString(t types.Type, v Value) string {
+	var b bytes.Buffer
+	writePrint(&b, t, v)
+	return b.String()
+}
+
+func writePrint(buf *bytes.Buffer, t types.Type, v Value) {
+	switch t := t.Underlying().(type) {
+	case *types.Basic:
+		if t.Kind() == types.UnsafePointer {
+			fmt.Fprintf(buf, "%#x", addrOf(v))
+			return
+		}
+		writeValue(buf, v)
+
+	case *types.Pointer, *types.Map, *types.Chan, *types.Signature:
+		fmt.Fprintf(buf, "%#x", addrOf(v))
+
+	case *types.Slice:
+		s, _ := v.(Slice)
+		fmt.Fprintf(buf, "[%d/%d]%#x", len(s), cap(s), addrOf(s))
+
+	case *types.Interface:
+		iface, _ := v.(Iface)
+		if iface.T == nil {
+			buf.WriteString("(0x0,0x0)")
+			return
+		}
+		fmt.Fprintf(buf, "(%#x,%#x)", typeAddr(iface.T), dataAddr(iface.V))
+
+	default:
+		p := &fmtPrinter{buf: buf}
+		p.printValue(t, v, 0)
+	}
+}
+
+// Formatter 将类型为T的值V包装为fmt.Formatter
+// 用于fmt包的模拟实现, 支持%v/%+v/%#v格式, 其它格式直接交给fmt处理
+type Formatter struct {
+	T types.Type
+	V Value
+}
+
+func (x Formatter) Format(f fmt.State, verb rune) {
+	if verb != 'v' {
+		v := x.V
+		if iface, ok := v.(Iface); ok {
+			v = iface.V
+		}
+		fmt.Fprintf(f, fmtDirective(f, verb), v)
+		return
+	}
+
+	var buf bytes.Buffer
+	p := &fmtPrinter{buf: &buf, plus: f.Flag('+'), sharp: f.Flag('#')}
+
+	// 参数本身是接口时, fmt看到的是接口的动态值
+	if iface, ok := x.V.(Iface); ok && types.IsInterface(x.T) {
+		if iface.T == nil {
+			buf.WriteString("<nil>")
+		} else {
+			p.printValue(iface.T, iface.V, 0)
+		}
+	} else {
+		p.printValue(x.T, x.V, 0)
+	}
+
+	fmt.Fprintf(f, fmtDirective(f, 's'), buf.String())
+}
+
+// 根据fmt.State重建格式指令, 保留宽度和对齐
+func fmtDirective(f fmt.State, verb rune) string {
+	var b bytes.Buffer
+	b.WriteByte('%')
+	for _, c := range "+-# 0" {
+		if (verb == 's' && (c == '+' || c == '#')) || !f.Flag(int(c)) {
+			continue
+		}
+		b.WriteRune(c)
+	}
+	if w, ok := f.Width(); ok {
+		fmt.Fprintf(&b, "%d", w)
+	}
+	if p, ok := f.Precision(); ok && verb != 's' {
+		fmt.Fprintf(&b, ".%d", p)
+	}
+	b.WriteRune(verb)
+	return b.String()
+}
+
+type fmtPrinter struct {
+	buf   *bytes.Buffer
+	plus  bool // %+v: 输出结构体的字段名
+	sharp bool // %#v: 输出Go语法格式
+}
+
+func (p *fmtPrinter) typeString(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string { return pkg.Name() })
+}
+
+func (p *fmtPrinter) printValue(t types.Type, v Value, depth int) {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Kind() == types.UnsafePointer {
+			p.printPointer(t, v)
+			return
+		}
+		if p.sharp {
+			fmt.Fprintf(p.buf, "%#v", v)
+		} else {
+			fmt.Fprintf(p.buf, "%v", v)
+		}
+
+	case *types.Struct:
+		fields, _ := v.(Struct)
+		if p.sharp {
+			p.buf.WriteString(p.typeString(t))
+		}
+		p.buf.WriteByte('{')
+		for i := 0; i < u.NumFields(); i++ {
+			if i > 0 {
+				if p.sharp {
+					p.buf.WriteString(", ")
+				} else {
+					p.buf.WriteByte(' ')
+				}
+			}
+			if p.plus || p.sharp {
+				p.buf.WriteString(u.Field(i).Name())
+				p.buf.WriteByte(':')
+			}
+			p.printValue(u.Field(i).Type(), fields[i], depth+1)
+		}
+		p.buf.WriteByte('}')
+
+	case *types.Array:
+		p.printElems(t, u.Elem(), []Value(v.(Array)), depth)
+
+	case *types.Slice:
+		s, _ := v.(Slice)
+		if p.sharp && s == nil {
+			p.buf.WriteString(p.typeString(t) + "(nil)")
+			return
+		}
+		p.printElems(t, u.Elem(), []Value(s), depth)
+
+	case *types.Map:
+		m, _ := v.(*Map)
+		if p.sharp && m == nil {
+			p.buf.WriteString(p.typeString(t) + "(nil)")
+			return
+		}
+		if p.sharp {
+			p.buf.WriteString(p.typeString(t) + "{")
+		} else {
+			p.buf.WriteString("map[")
+		}
+		for i, kv := range sortedEntries(m) {
+			if i > 0 {
+				if p.sharp {
+					p.buf.WriteString(", ")
+				} else {
+					p.buf.WriteByte(' ')
+				}
+			}
+			p.printValue(u.Key(), kv[0], depth+1)
+			p.buf.WriteByte(':')
+			p.printValue(u.Elem(), kv[1], depth+1)
+		}
+		if p.sharp {
+			p.buf.WriteByte('}')
+		} else {
+			p.buf.WriteByte(']')
+		}
+
+	case *types.Interface:
+		iface, _ := v.(Iface)
+		if iface.T == nil {
+			if p.sharp {
+				p.buf.WriteString(p.typeString(t) + "(nil)")
+			} else {
+				p.buf.WriteString("<nil>")
+			}
+			return
+		}
+		p.printValue(iface.T, iface.V, depth+1)
+
+	case *types.Pointer:
+		// 最外层指向复合类型的指针输出为&{...}的形式
+		if ptr, ok := v.(*Value); ok && ptr != nil && depth == 0 {
+			switch u.Elem().Underlying().(type) {
+			case *types.Struct, *types.Array, *types.Slice, *types.Map:
+				p.buf.WriteByte('&')
+				p.printValue(u.Elem(), *ptr, depth+1)
+				return
+			}
+		}
+		p.printPointer(t, v)
+
+	default: // chan, func
+		p.printPointer(t, v)
+	}
+}
+
+func (p *fmtPrinter) printElems(t, elem types.Type, elems []Value, depth int) {
+	if p.sharp {
+		p.buf.WriteString(p.typeString(t) + "{")
+	} else {
+		p.buf.WriteByte('[')
+	}
+	for i, e := range elems {
+		if i > 0 {
+			if p.sharp {
+				p.buf.WriteString(", ")
+			} else {
+				p.buf.WriteByte(' ')
+			}
+		}
+		p.printValue(elem, e, depth+1)
+	}
+	if p.sharp {
+		p.buf.WriteByte('}')
+	} else {
+		p.buf.WriteByte(']')
+	}
+}
+
+func (p *fmtPrinter) printPointer(t types.Type, v Value) {
+	addr := addrOf(v)
+	switch {
+	case p.sharp:
+		fmt.Fprintf(p.buf, "(%s)(", p.typeString(t))
+		if addr == 0 {
+			p.buf.WriteString("nil")
+		} else {
+			fmt.Fprintf(p.buf, "%#x", addr)
+		}
+		p.buf.WriteByte(')')
+	case addr == 0:
+		p.buf.WriteString("<nil>")
+	default:
+		fmt.Fprintf(p.buf, "%#x", addr)
+	}
+}
+
+// 按照fmt的规则对map的键排序, map的遍历顺序不确定, 排序后输出总是相同的
+func sortedEntries(m *Map) (entries [][2]Value) {
+	m.Range(func(k, v Value) bool {
+		entries = append(entries, [2]Value{k, v})
+		return true
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareKey(entries[i][0], entries[j][0]) < 0
+	})
+	return
+}
+
+// 比较map的两个键, 返回-1/0/1:
+// 数值/字符串按大小, NaN在最前面, false在true之前, 指针类的值按地址,
+// 结构体和数组逐个比较元素, 接口先比较动态类型的名字再比较动态值, nil接口在最前面
+func compareKey(x, y Value) int {
+	switch x := x.(type) {
+	case Struct:
+		return compareElems(x, y.(Struct))
+	case Array:
+		return compareElems(x, y.(Array))
+	case Iface:
+		y := y.(Iface)
+		switch {
+		case x.T == nil || y.T == nil:
+			return cmp.Compare(boolInt(x.T != nil), boolInt(y.T != nil))
+		case !types.Identical(x.T, y.T):
+			return strings.Compare(x.T.String(), y.T.String())
+		}
+		return compareKey(x.V, y.V)
+	}
+
+	a, b := reflect.ValueOf(x), reflect.ValueOf(y)
+	if !a.IsValid() || !b.IsValid() || a.Kind() != b.Kind() {
+		return 0
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		if c := cmp.Compare(real(a.Complex()), real(b.Complex())); c != 0 {
+			return c
+		}
+		return cmp.Compare(imag(a.Complex()), imag(b.Complex()))
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return cmp.Compare(boolInt(a.Bool()), boolInt(b.Bool()))
+	}
+	return cmp.Compare(addrOf(x), addrOf(y))
+}
+
+func compareElems(x, y []Value) int {
+	for i := range x {
+		if c := compareKey(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 指针类的值(指针/切片/map/函数)对应的地址, nil或其它类型返回0
+func addrOf(v Value) uintptr {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return rv.Pointer()
+	}
+	return 0
+}
+
+// 接口的数据字: 指针类的值就是其地址, 其它值和gc一样装箱到堆上
+func dataAddr(v Value) uintptr {
+	if addr := addrOf(v); addr != 0 {
+		return addr
+	}
+	box := v
+	return reflect.ValueOf(&box).Pointer()
+}
+
+var typeAddrs struct {
+	sync.Mutex
+	m typeutil.Map
+}
+
+// 类型的地址, 同一类型总是对应同一个地址
+func typeAddr(t types.Type) uintptr {
+	typeAddrs.Lock()
+	defer typeAddrs.Unlock()
+
+	p, _ := typeAddrs.m.At(t).(*byte)
+	if p == nil {
+		p = new(byte)
+		typeAddrs.m.Set(t, p)
+	}
+	return reflect.ValueOf(p).Pointer()
+}
@@ -0,0 +1,76 @@
+package watypes
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// 和gc的fmt输出比较, 模拟的值和Go的值对应
+func TestFormatter(t *testing.T) {
+	type point struct {
+		x int
+		y [2]int
+	}
+	pkg := types.NewPackage("main", "watypes")
+	tNamed := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "point", nil), tPoint, nil)
+	tMap := types.NewMap(tString, tInt)
+	tAny := types.NewInterfaceType(nil, nil).Complete()
+
+	m := NewMap(tString)
+	m.Update("b", 2)
+	m.Update("a", 1)
+	m.Update("c", 3)
+	var pt Value = Struct{1, Array{2, 3}}
+
+	for _, tt := range []struct {
+		t    types.Type
+		v    Value
+		goV  any
+		verb string
+	}{
+		{tInt, 42, 42, "%v"},
+		{tInt, 42, 42, "%5d"},
+		{tString, "wa", "wa", "%-4v|"},
+		{tPoint, pt, struct {
+			x int
+			y [2]int
+		}{1, [2]int{2, 3}}, "%v"},
+		{tPoint, pt, point{1, [2]int{2, 3}}, "%+v"},
+		{tNamed, pt, point{1, [2]int{2, 3}}, "%#v"},
+		{types.NewPointer(tNamed), &pt, &point{1, [2]int{2, 3}}, "%v"},
+		{types.NewSlice(tInt), Slice{1, 2}, []int{1, 2}, "%v"},
+		{types.NewSlice(tInt), Slice(nil), []int(nil), "%#v"},
+		// map按键排序输出
+		{tMap, m, map[string]int{"a": 1, "b": 2, "c": 3}, "%v"},
+		{tAny, Iface{}, nil, "%v"},
+		{tAny, Iface{tInt, 7}, 7, "%v"},
+	} {
+		want := fmt.Sprintf(tt.verb, tt.goV)
+		if got := fmt.Sprintf(tt.verb, Formatter{tt.t, tt.v}); got != want {
+			t.Errorf("%s %v: got %q, 期望 %q", tt.verb, tt.t, got, want)
+		}
+	}
+}
+
+// print/println的输出
+func TestPrintString(t *testing.T) {
+	tAny := types.NewInterfaceType(nil, nil).Complete()
+	for _, tt := range []struct {
+		t    types.Type
+		v    Value
+		want string
+	}{
+		{tInt, -3, "-3"},
+		{tString, "wa", "wa"},
+		{types.Typ[types.Bool], true, "true"},
+		{types.NewSlice(tInt), Slice(nil), "[0/0]0x0"},
+		{types.NewPointer(tInt), (*Value)(nil), "0x0"},
+		{tAny, Iface{}, "(0x0,0x0)"},
+	} {
+		if got := PrintString(tt.t, tt.v); got != tt.want {
+			t.Errorf("PrintString(%v, %v) = %q, 期望 %q", tt.t, tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package watypes
+
+import (
+	"go/types"
+	"hash/fnv"
+	"math"
+)
+
+// Hash 计算类型为t的值v的哈希值, 和Equals保持一致: 相等的值哈希值一定相同
+// 用于map的键, 动态类型不可比较的接口值会触发运行时panic
+func Hash(t types.Type, v Value) uint64 {
+	switch t := t.Underlying().(type) {
+	case *types.Struct:
+		s := v.(Struct)
+		var h uint64
+		for i := 0; i < t.NumFields(); i++ {
+			h = hashMix(h, Hash(t.Field(i).Type(), s[i]))
+		}
+		return h
+
+	case *types.Array:
+		var h uint64
+		for _, e := range v.(Array) {
+			h = hashMix(h, Hash(t.Elem(), e))
+		}
+		return h
+
+	case *types.Interface:
+		iface := v.(Iface)
+		if iface.T == nil {
+			return 0
+		}
+		if !types.Comparable(iface.T) {
+			panic(RuntimeError("hash of unhashable type " + iface.T.String()))
+		}
+		return hashMix(uint64(typeAddr(iface.T)), Hash(iface.T, iface.V))
+
+	case *types.Pointer, *types.Chan:
+		return uint64(addrOf(v))
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case int:
+		return uint64(v)
+	case int8:
+		return uint64(v)
+	case int16:
+		return uint64(v)
+	case int32:
+		return uint64(v)
+	case int64:
+		return uint64(v)
+	case uint:
+		return uint64(v)
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	case uintptr:
+		return uint64(v)
+	case float32:
+		return hashFloat(float64(v))
+	case float64:
+		return hashFloat(v)
+	case complex64:
+		return hashMix(hashFloat(float64(real(v))), hashFloat(float64(imag(v))))
+	case complex128:
+		return hashMix(hashFloat(real(v)), hashFloat(imag(v)))
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(v))
+		return h.Sum64()
+	}
+
+	panic(RuntimeError("hash of unhashable type " + t.String()))
+}
+
+func hashFloat(f float64) uint64 {
+	if f == 0 {
+		return 0 // +0和-0相等
+	}
+	return math.Float64bits(f)
+}
+
+func hashMix(h, x uint64) uint64 {
+	return h*31 + x
+}
@@ -13,11 +13,34 @@ type Value interface{}
 
 // 返回地址addr处存储的T类型的值
 func Load(T types.Type, addr *Value) Value {
-	return *addr
+	return Copy(*addr)
 }
 
 // 将类型为T的值v存入地址addr中
+//
+// 结构体和数组逐个元素写入原来的存储, 之前通过FieldAddr/IndexAddr
+// 取得的字段和元素地址仍然指向同一个变量
 func Store(T types.Type, addr *Value, v Value) {
+	storeInto(addr, Copy(v))
+}
+
+func storeInto(addr *Value, v Value) {
+	switch v := v.(type) {
+	case Struct:
+		if dst, ok := (*addr).(Struct); ok && len(dst) == len(v) {
+			for i := range v {
+				storeInto(&dst[i], v[i])
+			}
+			return
+		}
+	case Array:
+		if dst, ok := (*addr).(Array); ok && len(dst) == len(v) {
+			for i := range v {
+				storeInto(&dst[i], v[i])
+			}
+			return
+		}
+	}
 	*addr = v
 }
 
@@ -48,7 +71,49 @@ func writeValue(buf *bytes.Buffer, v Value) {
 	}
 }
 
+// Equals 按照Go语言的比较规则判断类型为t的两个值是否相等
 func Equals(t types.Type, x, y Value) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Struct:
+		xs, ys := x.(Struct), y.(Struct)
+		for i := 0; i < t.NumFields(); i++ {
+			if !Equals(t.Field(i).Type(), xs[i], ys[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *types.Array:
+		xa, ya := x.(Array), y.(Array)
+		for i := range xa {
+			if !Equals(t.Elem(), xa[i], ya[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *types.Interface:
+		// 先比较动态类型, 再比较动态值
+		xi, yi := x.(Iface), y.(Iface)
+		if xi.T == nil || yi.T == nil {
+			return xi.T == nil && yi.T == nil
+		}
+		if !types.Identical(xi.T, yi.T) {
+			return false
+		}
+		if !types.Comparable(xi.T) {
+			panic(RuntimeError("comparing uncomparable type " + xi.T.String()))
+		}
+		return Equals(xi.T, xi.V, yi.V)
+
+	case *types.Slice, *types.Map, *types.Signature:
+		// 只能和nil比较
+		return isNil(x) && isNil(y)
+
+	case *types.Chan:
+		return x == y
+	}
+
 	switch x := x.(type) {
 	case bool:
 		return x == y.(bool)
@@ -90,3 +155,18 @@ func Equals(t types.Type, x, y Value) bool {
 
 	panic(fmt.Sprintf("comparing uncomparable type %s", t))
 }
+
+// 判断切片/map/函数等引用类型的值是否为nil
+func isNil(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case Slice:
+		return v == nil
+	case *Map:
+		return v == nil
+	case *ssa.Function:
+		return v == nil
+	}
+	return false
+}
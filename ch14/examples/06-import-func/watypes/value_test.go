@@ -0,0 +1,97 @@
+package watypes
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+var (
+	tInt    = types.Typ[types.Int]
+	tString = types.Typ[types.String]
+
+	// struct { x int; y [2]int }
+	tPoint = types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, nil, "x", tInt, false),
+		types.NewField(token.NoPos, nil, "y", types.NewArray(tInt, 2), false),
+	}, nil)
+)
+
+// Store之后, 之前取得的元素和字段地址仍然指向同一个变量
+func TestStoreAlias(t *testing.T) {
+	// var a [3]int; p := &a[0]; a = [3]int{1, 2, 3}
+	var a Value = Array{0, 0, 0}
+	p := &a.(Array)[0]
+	Store(types.NewArray(tInt, 3), &a, Array{1, 2, 3})
+	if *p != 1 {
+		t.Errorf("*p = %v, 期望 1", *p)
+	}
+
+	// var s T; p := &s.y[1]; q := &s.x; s = T{5, [2]int{6, 7}}
+	var s Value = Struct{0, Array{0, 0}}
+	p = &s.(Struct)[1].(Array)[1]
+	q := &s.(Struct)[0]
+	Store(tPoint, &s, Struct{5, Array{6, 7}})
+	if *p != 7 || *q != 5 {
+		t.Errorf("*p, *q = %v, %v, 期望 7, 5", *p, *q)
+	}
+}
+
+// 存入的值和原来的值不共享存储
+func TestStoreCopy(t *testing.T) {
+	v := Struct{1, Array{2, 3}}
+	var s Value = Struct{0, Array{0, 0}}
+	Store(tPoint, &s, v)
+	v[1].(Array)[0] = 100
+	if got := s.(Struct)[1].(Array)[0]; got != 2 {
+		t.Errorf("s.y[0] = %v, 期望 2", got)
+	}
+
+	// 目标还未初始化时直接存入副本
+	var u Value
+	Store(tPoint, &u, v)
+	v[0] = 100
+	if got := u.(Struct)[0]; got != 1 {
+		t.Errorf("u.x = %v, 期望 1", got)
+	}
+}
+
+func TestEquals(t *testing.T) {
+	tAny := types.NewInterfaceType(nil, nil).Complete()
+	tSlice := types.NewSlice(tInt)
+
+	for i, tt := range []struct {
+		t    types.Type
+		x, y Value
+		want bool
+	}{
+		{tInt, 1, 1, true},
+		{tInt, 1, 2, false},
+		{tString, "a", "a", true},
+		{tPoint, Struct{1, Array{2, 3}}, Struct{1, Array{2, 3}}, true},
+		{tPoint, Struct{1, Array{2, 3}}, Struct{1, Array{2, 4}}, false},
+		{tAny, Iface{}, Iface{}, true},
+		{tAny, Iface{}, Iface{tInt, 0}, false},
+		{tAny, Iface{tInt, 1}, Iface{tInt, 1}, true},
+		// 动态值相同但动态类型不同
+		{tAny, Iface{tInt, "a"}, Iface{tString, "a"}, false},
+		{tSlice, Slice(nil), Slice(nil), true},
+		{tSlice, Slice{}, Slice(nil), false},
+	} {
+		if got := Equals(tt.t, tt.x, tt.y); got != tt.want {
+			t.Errorf("%d: Equals(%v, %v) = %v, 期望 %v", i, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+// 动态类型不可比较的接口值比较时panic
+func TestEqualsUncomparable(t *testing.T) {
+	tAny := types.NewInterfaceType(nil, nil).Complete()
+	x := Iface{types.NewSlice(tInt), Slice{}}
+	defer func() {
+		if _, ok := recover().(RuntimeError); !ok {
+			t.Errorf("期望RuntimeError")
+		}
+	}()
+	Equals(tAny, x, x)
+}
@@ -0,0 +1,310 @@
+// 构建驱动: 调用LLVM工具链将生成的LLVM-IR编译为-emit指定的产物,
+// 由ch14的LLVM后端和ch16的wcc共用
+package llvmdriver
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 产物类型:
+//
+//	ll   LLVM-IR, 指定-O1以上时经过opt优化
+//	bc   LLVM位码
+//	asm  汇编代码
+//	obj  目标文件
+//	exe  可执行程序
+//
+// 优先由clang完成全部步骤; 没有clang时依次调用opt/llc, 最后用系统的C编译器链接
+
+// 各种产物的默认扩展名
+var emitExt = map[string]string{
+	"ll":  ".ll",
+	"bc":  ".bc",
+	"asm": ".s",
+	"obj": ".o",
+	"exe": ".exe",
+}
+
+// 构建参数
+//
+// 字段由NewConfig注册的命令行参数设置, 调用者可以在Build之前读取或修改
+type Config struct {
+	OptLevel  int      // 优化级别, 对应-O0..-O3
+	Emit      string   // 产物类型
+	Output    string   // 产物路径, 为空时根据基础路径和产物类型生成
+	KeepTemps bool     // 是否保留中间文件
+	Target    string   // 目标三元组, 为空时使用工具链的默认平台
+	Clang     string   // 命令行指定的工具路径, 为空时在PATH中查找
+	LLC       string   // 同上
+	Opt       string   // 同上
+	CC        string   // 没有clang时用于链接的C编译器
+	Inputs    []string // 链接时追加的C源文件或目标文件
+}
+
+// 在flag集合中注册构建参数, emit为默认的产物类型
+func NewConfig(fs *flag.FlagSet, emit string) *Config {
+	c := &Config{}
+	for level := 0; level <= 3; level++ {
+		level := level
+		fs.BoolFunc(fmt.Sprintf("O%d", level), fmt.Sprintf("优化级别%d", level), func(string) error {
+			c.OptLevel = level
+			return nil
+		})
+	}
+	fs.StringVar(&c.Emit, "emit", emit, "产物类型: ll|bc|asm|obj|exe")
+	fs.StringVar(&c.Output, "o", "", "产物路径")
+	fs.BoolVar(&c.KeepTemps, "keep-temps", false, "保留中间文件")
+	fs.StringVar(&c.Target, "target", "", "目标三元组, 比如aarch64-linux-gnu")
+	fs.StringVar(&c.Clang, "clang", "", "clang的路径")
+	fs.StringVar(&c.LLC, "llc", "", "llc的路径")
+	fs.StringVar(&c.Opt, "opt", "", "opt的路径")
+	fs.StringVar(&c.CC, "cc", "", "链接用的C编译器的路径")
+	return c
+}
+
+// 找到的工具, 为空表示没有找到
+type toolchain struct {
+	clang, llc, opt, cc string
+}
+
+// 查找工具: 命令行指定的路径必须存在, 否则在PATH中依次查找候选的名字
+func lookTool(flagName, path string, names ...string) (string, error) {
+	if path != "" {
+		p, err := exec.LookPath(path)
+		if err != nil {
+			return "", fmt.Errorf("找不到-%s指定的程序%s", flagName, path)
+		}
+		return p, nil
+	}
+	for _, name := range names {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// 带版本号后缀的LLVM工具名, 比如Debian中的clang-14
+func llvmToolNames(name string) []string {
+	names := []string{name}
+	for v := 20; v >= 11; v-- {
+		names = append(names, fmt.Sprintf("%s-%d", name, v))
+	}
+	return names
+}
+
+// 查找构建当前产物需要的工具, 缺少工具时返回明确的诊断信息
+func (c *Config) findToolchain() (*toolchain, error) {
+	if _, ok := emitExt[c.Emit]; !ok {
+		return nil, fmt.Errorf("不支持的产物类型-emit=%s, 可选ll|bc|asm|obj|exe", c.Emit)
+	}
+
+	tc := &toolchain{}
+	var err error
+	if tc.clang, err = lookTool("clang", c.Clang, llvmToolNames("clang")...); err != nil {
+		return nil, err
+	}
+	if tc.llc, err = lookTool("llc", c.LLC, llvmToolNames("llc")...); err != nil {
+		return nil, err
+	}
+	if tc.opt, err = lookTool("opt", c.Opt, llvmToolNames("opt")...); err != nil {
+		return nil, err
+	}
+	if tc.cc, err = lookTool("cc", c.CC, "cc", "gcc"); err != nil {
+		return nil, err
+	}
+
+	// 显式指定了clang时只用clang
+	if c.Clang != "" {
+		return tc, nil
+	}
+	if c.Emit == "ll" && c.OptLevel == 0 {
+		return tc, nil
+	}
+	if tc.clang != "" {
+		return tc, nil
+	}
+
+	var missing []string
+	switch c.Emit {
+	case "ll", "bc":
+		if tc.opt == "" {
+			missing = append(missing, "opt")
+		}
+	case "asm", "obj":
+		if tc.llc == "" {
+			missing = append(missing, "llc")
+		}
+	case "exe":
+		if tc.llc == "" {
+			missing = append(missing, "llc")
+		}
+		if tc.cc == "" {
+			missing = append(missing, "C编译器(cc/gcc)")
+		}
+		if c.Target != "" && tc.cc != "" {
+			return nil, fmt.Errorf("找不到clang: 交叉编译-target=%s的可执行程序需要clang", c.Target)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf(
+			"找不到LLVM工具链: 生成%s需要clang, 或者%s\n"+
+				"可以用-clang/-llc/-opt/-cc参数指定工具的路径, 或者将LLVM的bin目录加入PATH环境变量",
+			c.Emit, strings.Join(missing, "和"),
+		)
+	}
+	return tc, nil
+}
+
+// 编译llPath中的LLVM-IR, base为默认产物和中间文件的基础路径, 返回产物路径
+// 没有指定-keep-temps时删除生成的中间文件, llPath由调用者处理
+func (c *Config) Build(llPath, base string) (string, error) {
+	tc, err := c.findToolchain()
+	if err != nil {
+		return "", err
+	}
+
+	out := c.Output
+	if out == "" {
+		out = base + emitExt[c.Emit]
+	}
+
+	// 中间文件放在产物旁边, 便于-keep-temps时查看
+	var temps []string
+	temp := func(ext string) string {
+		p := base + ext
+		if p == out || p == llPath {
+			p = base + ".tmp" + ext
+		}
+		temps = append(temps, p)
+		return p
+	}
+	defer func() {
+		if c.KeepTemps {
+			return
+		}
+		for _, p := range temps {
+			os.Remove(p)
+		}
+	}()
+
+	olevel := fmt.Sprintf("-O%d", c.OptLevel)
+	switch {
+	case c.Emit == "ll" && c.OptLevel == 0:
+		if llPath != out {
+			err = copyFile(llPath, out)
+		}
+
+	case tc.clang != "":
+		args := []string{"-Wno-override-module", olevel}
+		if c.Target != "" {
+			args = append(args, "--target="+c.Target)
+		}
+		switch c.Emit {
+		case "ll":
+			args = append(args, "-S", "-emit-llvm")
+		case "bc":
+			args = append(args, "-c", "-emit-llvm")
+		case "asm":
+			args = append(args, "-S")
+		case "obj":
+			args = append(args, "-c")
+		}
+		args = append(args, llPath)
+		if c.Emit == "exe" {
+			args = append(args, c.Inputs...)
+		}
+		err = c.runTo(out, temp, tc.clang, args...)
+
+	default:
+		var optArgs, llcArgs []string
+		if c.Target != "" {
+			optArgs = append(optArgs, "-mtriple="+c.Target)
+			llcArgs = append(llcArgs, "-mtriple="+c.Target)
+		}
+		// opt不接受-O0, 只做格式转换时省略
+		if c.OptLevel > 0 {
+			optArgs = append(optArgs, olevel)
+		}
+
+		in := llPath
+		if c.Emit == "ll" || c.Emit == "bc" {
+			if c.Emit == "ll" {
+				optArgs = append(optArgs, "-S")
+			}
+			err = c.runTo(out, temp, tc.opt, append(optArgs, in)...)
+			break
+		}
+		if c.OptLevel > 0 && tc.opt != "" {
+			optimized := temp(".opt.ll")
+			if err = runTool(tc.opt, append(optArgs, "-S", in, "-o", optimized)...); err != nil {
+				return "", err
+			}
+			in = optimized
+		}
+
+		llcArgs = append(llcArgs, olevel, "-relocation-model=pic")
+		switch c.Emit {
+		case "asm":
+			err = runTool(tc.llc, append(llcArgs, in, "-o", out)...)
+		case "obj":
+			err = runTool(tc.llc, append(llcArgs, "-filetype=obj", in, "-o", out)...)
+		case "exe":
+			obj := temp(".o")
+			if err = runTool(tc.llc, append(llcArgs, "-filetype=obj", in, "-o", obj)...); err != nil {
+				return "", err
+			}
+			args := append([]string{olevel, obj}, c.Inputs...)
+			err = runTool(tc.cc, append(args, "-o", out)...)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// 运行工具生成out, out和输入相同时先写到中间文件再改名
+func (c *Config) runTo(out string, temp func(string) string, tool string, args ...string) error {
+	dst := out
+	for _, arg := range args {
+		if arg == out {
+			dst = temp(filepath.Ext(out))
+		}
+	}
+	if err := runTool(tool, append(args, "-o", dst)...); err != nil {
+		return err
+	}
+	if dst != out {
+		return os.Rename(dst, out)
+	}
+	return nil
+}
+
+// 运行工具, 失败时返回完整的命令行和工具的输出
+func runTool(tool string, args ...string) error {
+	cmd := exec.Command(tool, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("无法运行%s: %v", tool, err)
+		}
+		return fmt.Errorf("%s %s 失败: %v\n%s", filepath.Base(tool), strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0666)
+}
@@ -0,0 +1,169 @@
+// 调试信息的辅助函数, 由ch14的LLVM后端和ch16的wcc共用
+package lldebug
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/metadata"
+)
+
+// 给块中从第start条指令开始以及终结指令中还没有位置信息的指令设置位置loc
+func SetLoc(block *ir.Block, start int, loc *metadata.DILocation) {
+	for _, inst := range block.Insts[start:] {
+		attach(metadataOf(inst), loc)
+	}
+	if block.Term != nil {
+		attach(metadataOf(block.Term), loc)
+	}
+}
+
+func attach(mds *ir.Metadata, loc *metadata.DILocation) {
+	if mds == nil {
+		return
+	}
+	for _, md := range *mds {
+		if md.Name == "dbg" {
+			return
+		}
+	}
+	*mds = append(*mds, &metadata.Attachment{Name: "dbg", Node: loc})
+}
+
+// 指令中嵌入的Metadata字段
+// llir的指令只有读取元数据的方法, 修改需要按指令的具体类型访问字段
+func metadataOf(inst interface{}) *ir.Metadata {
+	switch x := inst.(type) {
+	case *ir.InstExtractValue:
+		return &x.Metadata
+	case *ir.InstInsertValue:
+		return &x.Metadata
+	case *ir.InstAdd:
+		return &x.Metadata
+	case *ir.InstFAdd:
+		return &x.Metadata
+	case *ir.InstSub:
+		return &x.Metadata
+	case *ir.InstFSub:
+		return &x.Metadata
+	case *ir.InstMul:
+		return &x.Metadata
+	case *ir.InstFMul:
+		return &x.Metadata
+	case *ir.InstUDiv:
+		return &x.Metadata
+	case *ir.InstSDiv:
+		return &x.Metadata
+	case *ir.InstFDiv:
+		return &x.Metadata
+	case *ir.InstURem:
+		return &x.Metadata
+	case *ir.InstSRem:
+		return &x.Metadata
+	case *ir.InstFRem:
+		return &x.Metadata
+	case *ir.InstShl:
+		return &x.Metadata
+	case *ir.InstLShr:
+		return &x.Metadata
+	case *ir.InstAShr:
+		return &x.Metadata
+	case *ir.InstAnd:
+		return &x.Metadata
+	case *ir.InstOr:
+		return &x.Metadata
+	case *ir.InstXor:
+		return &x.Metadata
+	case *ir.InstTrunc:
+		return &x.Metadata
+	case *ir.InstZExt:
+		return &x.Metadata
+	case *ir.InstSExt:
+		return &x.Metadata
+	case *ir.InstFPTrunc:
+		return &x.Metadata
+	case *ir.InstFPExt:
+		return &x.Metadata
+	case *ir.InstFPToUI:
+		return &x.Metadata
+	case *ir.InstFPToSI:
+		return &x.Metadata
+	case *ir.InstUIToFP:
+		return &x.Metadata
+	case *ir.InstSIToFP:
+		return &x.Metadata
+	case *ir.InstPtrToInt:
+		return &x.Metadata
+	case *ir.InstIntToPtr:
+		return &x.Metadata
+	case *ir.InstBitCast:
+		return &x.Metadata
+	case *ir.InstAddrSpaceCast:
+		return &x.Metadata
+	case *ir.InstAlloca:
+		return &x.Metadata
+	case *ir.InstLoad:
+		return &x.Metadata
+	case *ir.InstStore:
+		return &x.Metadata
+	case *ir.InstFence:
+		return &x.Metadata
+	case *ir.InstCmpXchg:
+		return &x.Metadata
+	case *ir.InstAtomicRMW:
+		return &x.Metadata
+	case *ir.InstGetElementPtr:
+		return &x.Metadata
+	case *ir.InstICmp:
+		return &x.Metadata
+	case *ir.InstFCmp:
+		return &x.Metadata
+	case *ir.InstPhi:
+		return &x.Metadata
+	case *ir.InstSelect:
+		return &x.Metadata
+	case *ir.InstFreeze:
+		return &x.Metadata
+	case *ir.InstCall:
+		return &x.Metadata
+	case *ir.InstVAArg:
+		return &x.Metadata
+	case *ir.InstLandingPad:
+		return &x.Metadata
+	case *ir.InstCatchPad:
+		return &x.Metadata
+	case *ir.InstCleanupPad:
+		return &x.Metadata
+	case *ir.InstFNeg:
+		return &x.Metadata
+	case *ir.InstExtractElement:
+		return &x.Metadata
+	case *ir.InstInsertElement:
+		return &x.Metadata
+	case *ir.InstShuffleVector:
+		return &x.Metadata
+	case *ir.TermRet:
+		return &x.Metadata
+	case *ir.TermBr:
+		return &x.Metadata
+	case *ir.TermCondBr:
+		return &x.Metadata
+	case *ir.TermSwitch:
+		return &x.Metadata
+	case *ir.TermIndirectBr:
+		return &x.Metadata
+	case *ir.TermInvoke:
+		return &x.Metadata
+	case *ir.TermCallBr:
+		return &x.Metadata
+	case *ir.TermResume:
+		return &x.Metadata
+	case *ir.TermCatchSwitch:
+		return &x.Metadata
+	case *ir.TermCatchRet:
+		return &x.Metadata
+	case *ir.TermCleanupRet:
+		return &x.Metadata
+	case *ir.TermUnreachable:
+		return &x.Metadata
+	}
+	return nil
+}
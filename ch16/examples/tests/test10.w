@@ -0,0 +1,34 @@
+func fib(n) {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func isPrime(n int) int {
+	if n < 2 {
+		return 0
+	}
+	for d := 2; d*d <= n; d = d + 1 {
+		if n%d == 0 {
+			return 0
+		}
+	}
+	return 1
+}
+
+i = 0
+while i < 10 {
+	print(i, fib(i))
+	i = i + 1
+}
+
+sum = 0
+for n := 0; n < 30; n = n + 1 {
+	if isPrime(n) {
+		sum = sum + n
+		print(n)
+	}
+}
+print(sum)
+print()
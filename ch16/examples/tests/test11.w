@@ -0,0 +1,17 @@
+a = 17
+b = 5
+print(a / b, a % b, -a / b, -a % b)
+print(-a, ^a, a & b, a | b, a ^ b, a &^ b)
+print(a << 3, -a >> 2, 1 << 70, a >> b)
+
+// 移位次数不小于64
+n = 64
+print(1 << 64, a << n, a >> n, -a >> n, -a >> 100, 1 << 63 >> 63)
+print(a < b, a > b, a == 17, a != b, !(a >= b), b <= 5)
+print(a > 1 && b > 10 || 0x10 == 16, a > 1 && b < 10)
+
+// 最小的整数除以-1
+min = 0 - 9223372036854775807 - 1
+m = -1
+print(min / m, min % m, min / -1, min % -1)
+print(min - 1, 9223372036854775807 + 1)
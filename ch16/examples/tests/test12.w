@@ -0,0 +1,10 @@
+func div(a, b) {
+	return a / b
+}
+
+i = 3
+while i >= 0 {
+	print(100 / i, 100 % i)
+	i = i - 1
+}
+print(div(1, 0))
@@ -0,0 +1,6 @@
+// 负的常量移位次数, 除数为0时被除数中的错误也要报告
+a = 1
+print(a << -1)
+print(a >> (2 - 3))
+print(x / 0)
+print(1 % (1 - 1))
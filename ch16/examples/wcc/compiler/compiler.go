@@ -0,0 +1,357 @@
+// Package compiler把.w语言的源程序编译为LLVM-IR, 也可以直接解释执行
+//
+// .w语言只有64位整数一种类型, 支持变量/赋值/print/if/while和函数:
+//
+//	func fib(n) {
+//		if n < 2 {
+//			return n
+//		}
+//		return fib(n-1) + fib(n-2)
+//	}
+//	i = 0
+//	while i < 10 {
+//		print(i, fib(i))
+//		i = i + 1
+//	}
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"strings"
+
+	"github.com/llir/llvm/asm"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/metadata"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Compiler保存编译一个源文件的全部状态, 零值可以直接使用
+// 同一个Compiler可以依次编译多个源文件, 但是不能并发使用
+type Compiler struct {
+	srcFile  string         // 源文件名, 用于诊断信息和调试信息
+	srcLines []string       // 源文件的各行, 用于诊断信息
+	fset     *token.FileSet // 源文件的位置信息
+	m        *ir.Module     // 生成的LLVM-IR
+	errs     ErrorList      // 收集到的错误
+
+	lineNo  int                       // 当前语句所在的行号
+	labelNo int                       // 基本块标签编号
+	fn      *ir.Func                  // 当前函数
+	block   *ir.Block                 // 当前基本块
+	vars    map[string]*ir.InstAlloca // 当前函数已定义的变量及其栈空间
+	printf  *ir.Func                  // C库的printf
+	formats map[int]*ir.Global        // print语句用到的printf格式字符串, 键为参数个数
+	divs    map[token.Token]*ir.Func  // 除法和取余的辅助函数
+	divZero *ir.Func                  // 报告除数为0的辅助函数
+
+	// 用户定义的函数
+	funcs   map[string]*ir.Func // 已定义的函数
+	curFunc string              // 当前函数名, main函数中为空
+
+	// 分析得到的语法树, 解释执行时使用
+	decls  []*ast.FuncDecl // 函数定义
+	params [][]*ast.Ident  // 各个函数的参数
+	stmts  []ast.Stmt      // main函数的语句
+
+	// 调试信息
+	dbgCompileUnit *metadata.DICompileUnit
+	dbgFile        *metadata.DIFile
+	dbgSubprogram  *metadata.DISubprogram               // 当前函数的DISubprogram
+	dbgInt         *metadata.DIBasicType                // i64对应的int类型
+	dbgExpr        *metadata.DIExpression               // llvm.dbg.declare使用的空表达式
+	dbgDeclare     *ir.Func                             // llvm.dbg.declare
+	dbgVars        map[string]*metadata.DILocalVariable // 当前函数的变量
+	dbgLoc         *metadata.DILocation                 // 当前语句的位置
+	dbgMark        int                                  // 当前基本块中还没有设置位置的第一条指令
+}
+
+// 编译从r读取的.w源程序, filename用于诊断信息和调试信息
+// 成功时把LLVM-IR写入w; 源程序有错误时不写入任何内容, 返回ErrorList
+func (c *Compiler) Compile(w io.Writer, r io.Reader, filename string) error {
+	if err := c.compile(r, filename); err != nil {
+		return err
+	}
+
+	// 生成的LLVM-IR用llir/asm重新分析一遍, 确保交给clang之前格式正确
+	ll := c.m.String()
+	if _, err := asm.ParseString(filename, ll); err != nil {
+		return fmt.Errorf("生成的LLVM-IR有错误: %v", err)
+	}
+	_, err := io.WriteString(w, ll)
+	return err
+}
+
+// 解释执行从r读取的.w源程序, print的输出写入w, 结果和编译出的程序相同
+// 源程序先经过和Compile相同的检查, 有错误时不执行任何语句, 返回ErrorList;
+// 运行时出错时返回*RuntimeError, 出错之前的输出已经写入w
+func (c *Compiler) Run(w io.Writer, r io.Reader, filename string) error {
+	if err := c.compile(r, filename); err != nil {
+		return err
+	}
+	return newInterp(c, w).run()
+}
+
+// 编译源程序, 生成的LLVM-IR保存在c.m中
+func (c *Compiler) compile(r io.Reader, filename string) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	*c = Compiler{
+		srcFile:  filename,
+		srcLines: strings.Split(string(src), "\n"),
+		fset:     token.NewFileSet(),
+		m:        ir.NewModule(),
+		vars:     map[string]*ir.InstAlloca{},
+		formats:  map[int]*ir.Global{},
+		divs:     map[token.Token]*ir.Func{},
+		funcs:    map[string]*ir.Func{},
+		dbgVars:  map[string]*metadata.DILocalVariable{},
+	}
+
+	// 分析整个源文件
+	// 有语法错误时不再继续, 其它错误全部收集后一起报告
+	c.decls, c.stmts, err = c.parseSource(filename, src)
+	if err != nil {
+		c.syntaxErrors(err)
+		return c.errs
+	}
+
+	// 模块的开头: 源文件名/调试信息/printf的声明
+	c.m.SourceFilename = filename
+	c.dbgInit(filename)
+	c.printf = c.m.NewFunc("printf", llvmTypes.I32, ir.NewParam("", llvmTypes.I8Ptr))
+	c.printf.Sig.Variadic = true
+	mainFunc := c.m.NewFunc("main", llvmTypes.I32)
+
+	// 先登记全部函数, 函数可以在定义之前调用, 也可以递归调用
+	c.params = make([][]*ast.Ident, len(c.decls))
+	valid := make([]bool, len(c.decls))
+	for i, fd := range c.decls {
+		c.params[i], valid[i] = c.declareFunc(fd)
+	}
+
+	// main函数
+	c.fn = mainFunc
+	c.dbgSubprogram = c.dbgMain(mainFunc)
+	c.setBlock(ir.NewBlock("entry"))
+
+	// 在入口处为全部变量分配栈空间
+	c.declareVars(c.stmts)
+
+	// 逐条语句生成LLVM-IR
+	c.processStmts(c.stmts)
+	c.block.NewRet(constant.NewInt(llvmTypes.I32, 0))
+	c.dbgFlush()
+
+	// 每个函数生成一个define
+	for i, fd := range c.decls {
+		if valid[i] {
+			c.processFunc(fd, c.params[i])
+		}
+	}
+	if len(c.errs) > 0 {
+		c.errs.Sort()
+		return c.errs
+	}
+	return nil
+}
+
+// 用go/parser分析.w源文件:
+// 顶层的函数定义原样作为Go的函数, 其余语句放入一个合成的main函数中
+// //line和/*line*/注释让AST节点的位置对应到.w源文件中的行号和列号
+func (c *Compiler) parseSource(filename string, src []byte) ([]*ast.FuncDecl, []ast.Stmt, error) {
+	src = bytes.Clone(src)
+	var sc scanner.Scanner
+	file := token.NewFileSet().AddFile(filename, -1, len(src))
+	sc.Init(file, src, nil, 0)
+
+	// 找出顶层函数定义的范围
+	var ranges [][2]int
+	depth, start, funcOffset, prev := 0, -1, 0, token.ILLEGAL
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch {
+		case tok == token.IDENT && lit == "while":
+			// while是.w语言的关键字, 替换为等长的"for  "后按Go的for语句分析
+			copy(src[file.Offset(pos):], "for  ")
+		case tok == token.FUNC:
+			funcOffset = file.Offset(pos)
+		case tok == token.IDENT && prev == token.FUNC && depth == 0 && start < 0:
+			start = funcOffset
+		case tok == token.LBRACE:
+			depth++
+		case tok == token.RBRACE:
+			depth--
+			if depth == 0 && start >= 0 {
+				ranges = append(ranges, [2]int{start, file.Offset(pos) + 1})
+				start = -1
+			}
+		}
+		prev = tok
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("package main\n\n")
+	for _, r := range ranges {
+		p := file.Position(file.Pos(r[0]))
+		fmt.Fprintf(&buf, "/*line %s:%d:%d*/%s\n\n", filename, p.Line, p.Column, src[r[0]:r[1]])
+	}
+
+	// 函数定义从main函数体中去掉, 保留换行使后面语句的位置不变
+	for _, r := range ranges {
+		for i := r[0]; i < r[1]; i++ {
+			if src[i] != '\n' {
+				src[i] = ' '
+			}
+		}
+	}
+	buf.WriteString("func main() {\n")
+	fmt.Fprintf(&buf, "//line %s:1:1\n", filename)
+	buf.Write(src)
+	buf.WriteString("\n}\n")
+
+	f, err := parser.ParseFile(c.fset, filename, buf.Bytes(), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(f.Decls) != len(ranges)+1 {
+		return nil, nil, scanner.ErrorList{{Pos: c.fset.Position(f.Decls[len(ranges)+1].Pos()), Msg: "unexpected declaration"}}
+	}
+	var decls []*ast.FuncDecl
+	for _, decl := range f.Decls[:len(ranges)] {
+		decls = append(decls, decl.(*ast.FuncDecl))
+	}
+	return decls, f.Decls[len(ranges)].(*ast.FuncDecl).Body.List, nil
+}
+
+// 函数名不能和生成的代码中的名字冲突
+var reservedNames = map[string]bool{
+	"main":   true,
+	"print":  true,
+	"printf": true,
+
+	// 除法的辅助函数用到的C库函数
+	"fflush":  true,
+	"dprintf": true,
+	"exit":    true,
+}
+
+// 登记函数, 返回参数列表
+// 参数和返回值都是整数, 参数可以写成(a, b)或者(a, b int), 返回值可以省略或者写成int
+func (c *Compiler) declareFunc(fd *ast.FuncDecl) ([]*ast.Ident, bool) {
+	c.lineNo = c.fset.Position(fd.Pos()).Line
+	name := fd.Name.Name
+	if reservedNames[name] {
+		c.errorAt(fd.Name.Pos(), ErrReservedFunc, "%s是保留的函数名", name)
+		return nil, false
+	}
+	if _, ok := c.funcs[name]; ok {
+		c.errorAt(fd.Name.Pos(), ErrRedefinedFunc, "函数%s重复定义", name)
+		return nil, false
+	}
+
+	isInt := func(expr ast.Expr) bool {
+		id, b := expr.(*ast.Ident)
+		return b && id.Name == "int"
+	}
+	var params []*ast.Ident
+	valid := fd.Recv == nil && fd.Type.TypeParams == nil
+	for _, field := range fd.Type.Params.List {
+		if len(field.Names) == 0 {
+			id, b := field.Type.(*ast.Ident)
+			valid = valid && b
+			params = append(params, id)
+		} else {
+			valid = valid && isInt(field.Type)
+			params = append(params, field.Names...)
+		}
+	}
+	if fd.Type.Results != nil {
+		results := fd.Type.Results.List
+		valid = valid && len(results) == 1 && len(results[0].Names) == 0 && isInt(results[0].Type)
+	}
+
+	// 函数定义有错误时也登记函数, 避免调用处再报告未定义的函数
+	var irParams []*ir.Param
+	for _, p := range params {
+		param := ir.NewParam("", llvmTypes.I64)
+		if valid {
+			param.SetName(p.Name)
+		}
+		irParams = append(irParams, param)
+	}
+	c.funcs[name] = c.m.NewFunc(name, llvmTypes.I64, irParams...)
+	if !valid {
+		c.errorAt(fd.Type.Pos(), ErrFuncType, "函数的参数和返回值只能是整数")
+		return nil, false
+	}
+
+	seen := map[string]bool{}
+	for _, p := range params {
+		if seen[p.Name] {
+			c.errorAt(p.Pos(), ErrRedefinedArg, "函数%s的参数%s重复定义", name, p.Name)
+			return nil, false
+		}
+		seen[p.Name] = true
+	}
+	return params, true
+}
+
+// 函数生成define i64 @name(i64 %a, ...), 参数和变量一样保存在栈上
+// 函数体末尾没有return语句时返回0
+func (c *Compiler) processFunc(fd *ast.FuncDecl, params []*ast.Ident) bool {
+	c.curFunc = fd.Name.Name
+	c.vars = map[string]*ir.InstAlloca{}
+	c.dbgVars = map[string]*metadata.DILocalVariable{}
+	c.lineNo = c.fset.Position(fd.Pos()).Line
+
+	c.fn = c.funcs[c.curFunc]
+	c.dbgSubprogram = c.dbgFunc(c.fn, c.lineNo)
+	c.dbgLoc = nil
+	c.setBlock(ir.NewBlock("entry"))
+
+	// 参数复制到栈上
+	for i, p := range params {
+		c.setLoc(c.dbgLocation(p.Pos()))
+		c.vars[p.Name] = c.alloca(p.Name, c.fn.Params[i])
+		c.dbgDeclareVar(c.vars[p.Name], c.dbgParam(p.Name, i+1))
+	}
+	c.declareVars(fd.Body.List)
+
+	if b := c.processStmts(fd.Body.List); !b {
+		return false
+	}
+	c.setLoc(c.dbgLocation(fd.Body.Rbrace))
+	c.block.NewRet(constant.NewInt(llvmTypes.I64, 0))
+	c.dbgFlush()
+	return true
+}
+
+// 为变量分配栈空间并写入初始值
+func (c *Compiler) alloca(name string, init value.Value) *ir.InstAlloca {
+	slot := c.block.NewAlloca(llvmTypes.I64)
+	slot.SetName(name + ".addr")
+	c.block.NewStore(init, slot)
+	return slot
+}
+
+// 开始一个新的基本块, 基本块按照开始的顺序加入当前函数
+func (c *Compiler) setBlock(block *ir.Block) {
+	c.dbgFlush()
+	block.Parent = c.fn
+	c.fn.Blocks = append(c.fn.Blocks, block)
+	c.block = block
+	c.dbgMark = 0
+}
@@ -0,0 +1,118 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"llvmdriver"
+)
+
+// tests目录中有.out文件的测试程序分别解释执行和编译执行,
+// 输出(标准输出之后是标准错误)和退出码都要和.out文件一致
+// 有编译错误的程序两种方式报告的诊断信息也要相同
+func TestPrograms(t *testing.T) {
+	files, err := filepath.Glob("../../tests/*.out")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("没有找到测试程序: %v", err)
+	}
+	for _, outFile := range files {
+		name := strings.TrimSuffix(filepath.Base(outFile), ".out")
+		t.Run(name, func(t *testing.T) {
+			want, err := os.ReadFile(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := os.ReadFile(strings.TrimSuffix(outFile, ".out") + ".w")
+			if err != nil {
+				t.Fatal(err)
+			}
+			filename := name + ".w"
+
+			var c Compiler
+			var stdout bytes.Buffer
+			runErr := c.Run(&stdout, bytes.NewReader(src), filename)
+			if got := result(stdout.String(), runErr); got != string(want) {
+				t.Errorf("解释执行的结果:\n%s期望:\n%s", got, want)
+			}
+
+			var ll bytes.Buffer
+			compileErr := c.Compile(&ll, bytes.NewReader(src), filename)
+			if list, ok := runErr.(ErrorList); ok {
+				if got, want := result("", compileErr), result("", list); got != want {
+					t.Errorf("编译的诊断信息:\n%s解释执行的诊断信息:\n%s", got, want)
+				}
+				return
+			}
+			if compileErr != nil {
+				t.Fatalf("编译失败: %v", compileErr)
+			}
+			if got := runExe(t, ll.Bytes()); got != string(want) {
+				t.Errorf("编译执行的结果:\n%s期望:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// 和wcc命令行的输出相同: 标准输出之后是诊断信息或者运行时错误, 最后一行是退出码
+func result(stdout string, err error) string {
+	var buf bytes.Buffer
+	buf.WriteString(stdout)
+	code := 0
+	switch err := err.(type) {
+	case nil:
+	case ErrorList:
+		err.Fprint(&buf)
+		code = 1
+	case *RuntimeError:
+		fmt.Fprintln(&buf, err)
+		code = RuntimeErrorExitCode
+	default:
+		fmt.Fprintln(&buf, err)
+		code = 1
+	}
+	fmt.Fprintf(&buf, "exit %d\n", code)
+	return buf.String()
+}
+
+// 用LLVM工具链把LLVM-IR编译为可执行程序后运行, 返回和result格式相同的结果
+// 没有clang, 也没有llc时跳过
+func runExe(t *testing.T, ll []byte) string {
+	_, errClang := exec.LookPath("clang")
+	_, errLLC := exec.LookPath("llc")
+	if errClang != nil && errLLC != nil {
+		t.Skip("没有找到LLVM工具链")
+	}
+
+	dir := t.TempDir()
+	llPath := filepath.Join(dir, "a.ll")
+	if err := os.WriteFile(llPath, ll, 0666); err != nil {
+		t.Fatal(err)
+	}
+	build := llvmdriver.NewConfig(flag.NewFlagSet("wcc", flag.ContinueOnError), "exe")
+	exe, err := build.Build(llPath, filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		code = exitErr.ExitCode()
+	}
+	fmt.Fprintf(&buf, "exit %d\n", code)
+	return buf.String()
+}
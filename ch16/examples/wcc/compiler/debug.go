@@ -0,0 +1,169 @@
+package compiler
+
+import (
+	"go/token"
+	"path/filepath"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	llvmTypes "github.com/llir/llvm/ir/types"
+
+	"llvmdriver/lldebug"
+)
+
+// 注册匿名的元数据节点, 编号在输出时分配
+func (c *Compiler) dbgAdd(md metadata.Definition) {
+	c.m.MetadataDefs = append(c.m.MetadataDefs, md)
+}
+
+func (c *Compiler) dbgTuple(fields ...metadata.Field) *metadata.Tuple {
+	t := &metadata.Tuple{MetadataID: -1, Fields: fields}
+	c.dbgAdd(t)
+	return t
+}
+
+// 生成编译单元/源文件/int类型的调试信息
+func (c *Compiler) dbgInit(filename string) {
+	dir, _ := filepath.Abs(filepath.Dir(filename))
+	c.dbgFile = &metadata.DIFile{MetadataID: -1, Filename: filepath.Base(filename), Directory: dir}
+	c.dbgAdd(c.dbgFile)
+	// 源码是go/parser解析的Go语法, 和ch14的后端一样使用DW_LANG_Go, 调试器按Go的语法求值表达式
+	c.dbgCompileUnit = &metadata.DICompileUnit{
+		MetadataID:   -1,
+		Distinct:     true,
+		Language:     enum.DwarfLangGo,
+		File:         c.dbgFile,
+		Producer:     "wcc",
+		EmissionKind: enum.EmissionKindFullDebug,
+	}
+	c.dbgAdd(c.dbgCompileUnit)
+	c.dbgInt = c.dbgBasicType("int", 64)
+	c.dbgExpr = &metadata.DIExpression{MetadataID: -1}
+	c.dbgAdd(c.dbgExpr)
+
+	c.m.NamedMetadataDefs["llvm.dbg.cu"] = &metadata.NamedDef{
+		Name:  "llvm.dbg.cu",
+		Nodes: []metadata.Node{c.dbgCompileUnit},
+	}
+	dwarfVersion := c.dbgTuple(constant.NewInt(llvmTypes.I32, 7), &metadata.String{Value: "Dwarf Version"}, constant.NewInt(llvmTypes.I32, 4))
+	debugVersion := c.dbgTuple(constant.NewInt(llvmTypes.I32, 2), &metadata.String{Value: "Debug Info Version"}, constant.NewInt(llvmTypes.I32, 3))
+	c.m.NamedMetadataDefs["llvm.module.flags"] = &metadata.NamedDef{
+		Name:  "llvm.module.flags",
+		Nodes: []metadata.Node{dwarfVersion, debugVersion},
+	}
+
+	md := llvmTypes.Metadata
+	c.dbgDeclare = c.m.NewFunc("llvm.dbg.declare", llvmTypes.Void, ir.NewParam("", md), ir.NewParam("", md), ir.NewParam("", md))
+}
+
+func (c *Compiler) dbgBasicType(name string, bits uint64) *metadata.DIBasicType {
+	t := &metadata.DIBasicType{
+		MetadataID: -1,
+		Tag:        enum.DwarfTagBaseType,
+		Name:       name,
+		Size:       bits,
+		Encoding:   enum.DwarfAttEncodingSigned,
+	}
+	c.dbgAdd(t)
+	return t
+}
+
+// 函数对应的DISubprogram, sigTypes依次是返回值和参数的类型
+func (c *Compiler) dbgSubprogramOf(fn *ir.Func, line int, sigTypes ...metadata.Field) *metadata.DISubprogram {
+	sig := &metadata.DISubroutineType{MetadataID: -1, Types: c.dbgTuple(sigTypes...)}
+	c.dbgAdd(sig)
+	sp := &metadata.DISubprogram{
+		MetadataID: -1,
+		Distinct:   true,
+		Scope:      c.dbgFile,
+		Name:       fn.Name(),
+		File:       c.dbgFile,
+		Line:       int64(line),
+		Type:       sig,
+		ScopeLine:  int64(line),
+		SPFlags:    enum.DISPFlagDefinition,
+		Unit:       c.dbgCompileUnit,
+	}
+	c.dbgAdd(sp)
+	fn.Metadata = append(fn.Metadata, &metadata.Attachment{Name: "dbg", Node: sp})
+	return sp
+}
+
+// main函数对应的DISubprogram, main函数从第1行开始, 返回值是int32
+func (c *Compiler) dbgMain(fn *ir.Func) *metadata.DISubprogram {
+	return c.dbgSubprogramOf(fn, 1, c.dbgBasicType("int32", 32))
+}
+
+// 用户定义的函数对应的DISubprogram, 返回值和参数都是int
+func (c *Compiler) dbgFunc(fn *ir.Func, line int) *metadata.DISubprogram {
+	sigTypes := make([]metadata.Field, len(fn.Params)+1)
+	for i := range sigTypes {
+		sigTypes[i] = c.dbgInt
+	}
+	return c.dbgSubprogramOf(fn, line, sigTypes...)
+}
+
+// 语句的源码位置对应的DILocation, 行号和列号来自AST节点的位置
+func (c *Compiler) dbgLocation(pos token.Pos) *metadata.DILocation {
+	p := c.fset.Position(pos)
+	loc := &metadata.DILocation{
+		MetadataID: -1,
+		Line:       int64(p.Line),
+		Column:     int64(p.Column),
+		Scope:      c.dbgSubprogram,
+	}
+	c.dbgAdd(loc)
+	return loc
+}
+
+// 变量对应的DILocalVariable
+func (c *Compiler) dbgVariable(name string) *metadata.DILocalVariable {
+	if dv, ok := c.dbgVars[name]; ok {
+		return dv
+	}
+	return c.dbgParam(name, 0)
+}
+
+// 函数参数对应的DILocalVariable, arg是从1开始的参数序号, 普通变量为0
+func (c *Compiler) dbgParam(name string, arg int) *metadata.DILocalVariable {
+	dv := &metadata.DILocalVariable{
+		MetadataID: -1,
+		Name:       name,
+		Arg:        uint64(arg),
+		Scope:      c.dbgSubprogram,
+		File:       c.dbgFile,
+		Line:       int64(c.lineNo),
+		Type:       c.dbgInt,
+	}
+	c.dbgAdd(dv)
+	c.dbgVars[name] = dv
+	return dv
+}
+
+// 用llvm.dbg.declare描述变量在栈上的地址
+func (c *Compiler) dbgDeclareVar(slot *ir.InstAlloca, dv *metadata.DILocalVariable) {
+	c.block.NewCall(c.dbgDeclare,
+		&metadata.Value{Value: slot},
+		&metadata.Value{Value: dv},
+		&metadata.Value{Value: c.dbgExpr},
+	)
+}
+
+// 切换当前语句的位置, 之前生成的指令使用原来的位置
+func (c *Compiler) setLoc(loc *metadata.DILocation) {
+	c.dbgFlush()
+	c.dbgLoc = loc
+}
+
+// 给当前基本块中新生成的指令附加当前语句的位置
+func (c *Compiler) dbgFlush() {
+	if c.block == nil {
+		return
+	}
+	if c.dbgLoc != nil {
+		lldebug.SetLoc(c.block, c.dbgMark, c.dbgLoc)
+	}
+	c.dbgMark = len(c.block.Insts)
+}
@@ -0,0 +1,152 @@
+package compiler
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+// 错误码, 编号固定不变, 编辑器等工具可以根据错误码处理
+const (
+	ErrSyntax        = "W0001" // 语法错误
+	ErrUnsupported   = "W0002" // 不支持的语句或表达式
+	ErrAssign        = "W0003" // 不支持的赋值语句
+	ErrAssignLHS     = "W0004" // 赋值语句左侧不是变量
+	ErrUndefinedVar  = "W0005" // 引用未定义的变量
+	ErrOperator      = "W0006" // 不支持的运算
+	ErrConst         = "W0007" // 超出范围或者不是整数的常量
+	ErrDivZero       = "W0008" // 除数是常量0
+	ErrIfInit        = "W0009" // if语句包含初始化语句
+	ErrReservedFunc  = "W0010" // 函数名是保留的名字
+	ErrRedefinedFunc = "W0011" // 函数重复定义
+	ErrFuncType      = "W0012" // 参数或返回值不是整数
+	ErrRedefinedArg  = "W0013" // 参数重复定义
+	ErrReturn        = "W0014" // 不正确的return语句
+	ErrCall          = "W0015" // 不支持的函数调用
+	ErrPrintExpr     = "W0016" // print用在表达式中
+	ErrUndefinedFunc = "W0017" // 调用未定义的函数
+	ErrArgCount      = "W0018" // 参数个数不正确
+	ErrShiftCount    = "W0019" // 移位次数是负的常量
+)
+
+// 源程序中的一个错误
+type Error struct {
+	Code    string `json:"code"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	Source  string `json:"-"` // 出错的源码行, 行号超出源文件时为空
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: error[%s]: %s", e.File, e.Line, e.Column, e.Code, e.Message)
+}
+
+// 一次编译收集到的全部错误
+type ErrorList []*Error
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// 按位置排序
+func (list ErrorList) Sort() {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Line != list[j].Line {
+			return list[i].Line < list[j].Line
+		}
+		return list[i].Column < list[j].Column
+	})
+}
+
+// 输出全部错误, 每个错误后面是出错的源码行和指向出错列的^
+func (list ErrorList) Fprint(w io.Writer) {
+	for _, e := range list {
+		fmt.Fprintln(w, e)
+		if e.Source == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%5d | %s\n", e.Line, e.Source)
+
+		// ^之前的空白和源码行对齐, 制表符保持不变
+		var indent strings.Builder
+		for i, r := range e.Source {
+			if i >= e.Column-1 {
+				break
+			}
+			if r == '\t' {
+				indent.WriteRune('\t')
+			} else {
+				indent.WriteRune(' ')
+			}
+		}
+		fmt.Fprintf(w, "      | %s^\n", indent.String())
+	}
+}
+
+// 记录pos处的错误, 编译继续进行, 以便一次报告尽可能多的错误
+func (c *Compiler) errorAt(pos token.Pos, code string, format string, a ...interface{}) {
+	c.addError(code, c.fset.Position(pos), fmt.Sprintf(format, a...))
+}
+
+// 记录go/parser报告的全部语法错误
+func (c *Compiler) syntaxErrors(err error) {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		c.addError(ErrSyntax, token.Position{Filename: c.srcFile, Line: 1, Column: 1}, err.Error())
+		return
+	}
+	// 源程序末尾缺少}等错误可能报告在合成的代码中, 已经有其它错误时不再报告
+	inSource := 0
+	for _, e := range list {
+		if e.Pos.Line <= len(c.srcLines) {
+			inSource++
+		}
+	}
+	for _, e := range list {
+		if e.Pos.Line > len(c.srcLines) && inSource > 0 {
+			continue
+		}
+		c.addError(ErrSyntax, e.Pos, "语法错误: "+e.Msg)
+	}
+}
+
+func (c *Compiler) addError(code string, pos token.Position, msg string) {
+	e := &Error{
+		Code:    code,
+		File:    pos.Filename,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Message: msg,
+	}
+	if pos.Line >= 1 && pos.Line <= len(c.srcLines) {
+		e.Source = strings.TrimRight(c.srcLines[pos.Line-1], "\r")
+	}
+	c.errs = append(c.errs, e)
+}
+
+// 程序运行时的错误, 编译出的程序遇到同样的错误时输出相同的信息,
+// 然后以RuntimeErrorExitCode退出
+type RuntimeError struct {
+	Message string
+}
+
+func (e *RuntimeError) Error() string {
+	return "运行时错误: " + e.Message
+}
+
+// 运行时错误的退出码
+const RuntimeErrorExitCode = 2
+
+// 除数为0
+var DivideByZero = &RuntimeError{Message: "整数除以0"}
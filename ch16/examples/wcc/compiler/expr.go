@@ -0,0 +1,372 @@
+package compiler
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// 条件表达式, 返回i1类型的结果
+// 比较运算生成icmp, &&和||短路求值, 其它表达式的值不等于0时为真
+func (c *Compiler) processCond(expr ast.Expr) (value.Value, bool) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return c.processCond(e.X)
+
+	case *ast.UnaryExpr:
+		if e.Op == token.NOT {
+			x, b := c.processCond(e.X)
+			if !b {
+				return nil, false
+			}
+			return c.block.NewXor(x, constant.True), true
+		}
+
+	case *ast.BinaryExpr:
+		if pred, ok := icmpPred[e.Op]; ok {
+			x, b := c.processExpr(e.X)
+			if !b {
+				return nil, false
+			}
+			y, b := c.processExpr(e.Y)
+			if !b {
+				return nil, false
+			}
+			return c.block.NewICmp(pred, x, y), true
+		}
+		if e.Op == token.LAND || e.Op == token.LOR {
+			return c.processLogic(e)
+		}
+	}
+
+	x, b := c.processExpr(expr)
+	if !b {
+		return nil, false
+	}
+	return c.block.NewICmp(enum.IPredNE, x, constant.NewInt(llvmTypes.I64, 0)), true
+}
+
+// 比较运算对应的icmp条件
+var icmpPred = map[token.Token]enum.IPred{
+	token.EQL: enum.IPredEQ,
+	token.NEQ: enum.IPredNE,
+	token.LSS: enum.IPredSLT,
+	token.LEQ: enum.IPredSLE,
+	token.GTR: enum.IPredSGT,
+	token.GEQ: enum.IPredSGE,
+}
+
+// &&和||, 左侧的值已经能确定结果时不计算右侧, 在结束块中用phi合并结果
+func (c *Compiler) processLogic(e *ast.BinaryExpr) (value.Value, bool) {
+	c.labelNo++
+	prefix := "land"
+	if e.Op == token.LOR {
+		prefix = "lor"
+	}
+	rhsBlock := ir.NewBlock(prefix + ".rhs" + strconv.Itoa(c.labelNo))
+	endBlock := ir.NewBlock(prefix + ".end" + strconv.Itoa(c.labelNo))
+
+	x, b := c.processCond(e.X)
+	if !b {
+		return nil, false
+	}
+	xBlock := c.block
+	if e.Op == token.LAND {
+		c.block.NewCondBr(x, rhsBlock, endBlock)
+	} else {
+		c.block.NewCondBr(x, endBlock, rhsBlock)
+	}
+
+	c.setBlock(rhsBlock)
+	y, b := c.processCond(e.Y)
+	if !b {
+		return nil, false
+	}
+	yBlock := c.block
+	c.block.NewBr(endBlock)
+
+	c.setBlock(endBlock)
+	return c.block.NewPhi(
+		ir.NewIncoming(constant.NewBool(e.Op == token.LOR), xBlock),
+		ir.NewIncoming(y, yBlock),
+	), true
+}
+
+// 这个函数递归调用自己，分析表达式，生成计算表达式的指令。
+// 第一个返回值是输入表达式的结果，可能被上一级表达式引用。
+// 第二个返回值是输入表达式是否已被正确解析。
+func (c *Compiler) processExpr(expr interface{}) (value.Value, bool) {
+	if binExpr, b0 := expr.(*ast.BinaryExpr); b0 { // 二元表达式
+		switch binExpr.Op {
+		// 算术运算和位运算
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
+			token.AND, token.OR, token.XOR, token.AND_NOT, token.SHL, token.SHR:
+			left, bLeft := c.processExpr(binExpr.X)
+			if !bLeft { // 左分支包含语法错误
+				return nil, false
+			}
+			right, bRight := c.processExpr(binExpr.Y)
+			if !bRight { // 右分支包含语法错误
+				return nil, false
+			}
+			switch binExpr.Op {
+			case token.SHL, token.SHR:
+				// 和Go一样拒绝负的常量移位次数
+				if v, ok := constValue(binExpr.Y); ok && v < 0 {
+					c.errorAt(binExpr.Y.Pos(), ErrShiftCount, "移位次数%d是负数", v)
+					return nil, false
+				}
+				return c.shift(binExpr.Op, left, right), true
+			case token.AND_NOT:
+				// x &^ y即x & ^y
+				right = c.block.NewXor(right, constant.NewInt(llvmTypes.I64, -1))
+			case token.QUO, token.REM:
+				// 除数是常量0时在编译期报错, 先处理被除数, 被除数中的错误也能报告出来
+				v, ok := constValue(binExpr.Y)
+				if ok && v == 0 {
+					c.errorAt(binExpr.Y.Pos(), ErrDivZero, "除数为0")
+					return nil, false
+				}
+				// 除数不是常量时调用检查除数的辅助函数
+				if !ok || v == -1 {
+					return c.block.NewCall(c.divFunc(binExpr.Op), left, right), true
+				}
+			}
+			// 生成：left <op> right
+			return binOp(c.block, binExpr.Op, left, right), true
+
+		// 比较运算和逻辑运算得到i1, 扩展为i64
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return c.processBool(binExpr)
+
+		// 不支持其它运算
+		default:
+			c.errorAt(binExpr.OpPos, ErrOperator, "不支持的运算%s", binExpr.Op)
+			return nil, false
+		}
+	} else if unExpr, b0 := expr.(*ast.UnaryExpr); b0 { // 一元表达式
+		switch unExpr.Op {
+		case token.ADD:
+			return c.processExpr(unExpr.X)
+		case token.SUB, token.XOR:
+			x, b1 := c.processExpr(unExpr.X)
+			if !b1 {
+				return nil, false
+			}
+			if unExpr.Op == token.SUB {
+				return c.block.NewSub(constant.NewInt(llvmTypes.I64, 0), x), true
+			}
+			return c.block.NewXor(x, constant.NewInt(llvmTypes.I64, -1)), true
+		case token.NOT:
+			return c.processBool(unExpr)
+		default:
+			c.errorAt(unExpr.OpPos, ErrOperator, "不支持的运算%s", unExpr.Op)
+			return nil, false
+		}
+	} else if vExpr, b0 := expr.(*ast.Ident); b0 { // 树形表达式的最末端，单个变量
+		// 检查变量是否定义过
+		slot, ok := c.vars[vExpr.Name]
+		if !ok {
+			c.errorAt(vExpr.Pos(), ErrUndefinedVar, "引用未定义的变量%s", vExpr.Name)
+			return nil, false
+		}
+		// 读取变量
+		return c.block.NewLoad(llvmTypes.I64, slot), true
+	} else if cExpr, b0 := expr.(*ast.BasicLit); b0 { // 树形表达式的最末端，单个常量
+		v, ok := constValue(cExpr)
+		if !ok {
+			c.errorAt(cExpr.Pos(), ErrConst, "只支持64位整数常量")
+			return nil, false
+		}
+		// 十六进制等写法统一转换为十进制
+		return constant.NewInt(llvmTypes.I64, v), true
+	} else if callExpr, b0 := expr.(*ast.CallExpr); b0 { // 函数调用
+		return c.processCall(callExpr)
+	} else if pExpr, b0 := expr.(*ast.ParenExpr); b0 { // 括号表达式
+		return c.processExpr(pExpr.X)
+	} else { // 不支持其它的表达式
+		c.errorAt(expr.(ast.Node).Pos(), ErrUnsupported, "不支持的表达式")
+		return nil, false
+	}
+}
+
+// 算术运算和位运算对应的指令
+func binOp(block *ir.Block, op token.Token, x, y value.Value) value.Value {
+	switch op {
+	case token.ADD:
+		return block.NewAdd(x, y)
+	case token.SUB:
+		return block.NewSub(x, y)
+	case token.MUL:
+		return block.NewMul(x, y)
+	case token.QUO:
+		return block.NewSDiv(x, y)
+	case token.REM:
+		return block.NewSRem(x, y)
+	case token.AND, token.AND_NOT:
+		return block.NewAnd(x, y)
+	case token.OR:
+		return block.NewOr(x, y)
+	case token.XOR:
+		return block.NewXor(x, y)
+	case token.SHL:
+		return block.NewShl(x, y)
+	case token.SHR:
+		return block.NewAShr(x, y)
+	}
+	panic("unreachable")
+}
+
+// 移位运算, 和Go一样移位次数按无符号数处理:
+// 次数不小于64时左移得到0, 右移按符号位填充得到0或-1, 避免LLVM中超出位宽的移位得到不确定的结果
+func (c *Compiler) shift(op token.Token, x, y value.Value) value.Value {
+	inRange := c.block.NewICmp(enum.IPredULT, y, constant.NewInt(llvmTypes.I64, 64))
+	if op == token.SHL {
+		return c.block.NewSelect(inRange, c.block.NewShl(x, y), constant.NewInt(llvmTypes.I64, 0))
+	}
+	return c.block.NewAShr(x, c.block.NewSelect(inRange, y, constant.NewInt(llvmTypes.I64, 63)))
+}
+
+// 除法和取余的辅助函数, 行为和Go一致:
+// 除数为0时报告运行时错误并退出, 最小的整数除以-1得到它本身, 余数为0,
+// 避免sdiv/srem溢出时的未定义行为
+func (c *Compiler) divFunc(op token.Token) *ir.Func {
+	if fn, ok := c.divs[op]; ok {
+		return fn
+	}
+	name := "wcc.sdiv"
+	if op == token.REM {
+		name = "wcc.srem"
+	}
+	x, y := ir.NewParam("x", llvmTypes.I64), ir.NewParam("y", llvmTypes.I64)
+	fn := c.m.NewFunc(name, llvmTypes.I64, x, y)
+	fn.Linkage = enum.LinkageInternal
+	c.divs[op] = fn
+
+	zero := constant.NewInt(llvmTypes.I64, 0)
+	entry := fn.NewBlock("entry")
+	panicBlock := fn.NewBlock("panic")
+	check := fn.NewBlock("check")
+	neg := fn.NewBlock("neg")
+	div := fn.NewBlock("div")
+
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, y, zero), panicBlock, check)
+	panicBlock.NewCall(c.divZeroFunc())
+	panicBlock.NewUnreachable()
+	check.NewCondBr(check.NewICmp(enum.IPredEQ, y, constant.NewInt(llvmTypes.I64, -1)), neg, div)
+	if op == token.QUO {
+		neg.NewRet(neg.NewSub(zero, x))
+	} else {
+		neg.NewRet(zero)
+	}
+	div.NewRet(binOp(div, op, x, y))
+	return fn
+}
+
+// 除数为0时先输出printf缓存的内容, 然后在标准错误输出错误信息并退出
+func (c *Compiler) divZeroFunc() *ir.Func {
+	if c.divZero != nil {
+		return c.divZero
+	}
+	msg := c.cString("wcc.divzero.msg", DivideByZero.Error()+"\n")
+	fflush := c.m.NewFunc("fflush", llvmTypes.I32, ir.NewParam("", llvmTypes.I8Ptr))
+	dprintf := c.m.NewFunc("dprintf", llvmTypes.I32, ir.NewParam("", llvmTypes.I32), ir.NewParam("", llvmTypes.I8Ptr))
+	dprintf.Sig.Variadic = true
+	exit := c.m.NewFunc("exit", llvmTypes.Void, ir.NewParam("", llvmTypes.I32))
+	exit.FuncAttrs = []ir.FuncAttribute{enum.FuncAttrNoReturn}
+
+	c.divZero = c.m.NewFunc("wcc.divzero", llvmTypes.Void)
+	c.divZero.Linkage = enum.LinkageInternal
+	c.divZero.FuncAttrs = []ir.FuncAttribute{enum.FuncAttrNoReturn}
+	entry := c.divZero.NewBlock("entry")
+	entry.NewCall(fflush, constant.NewNull(llvmTypes.I8Ptr))
+	entry.NewCall(dprintf, constant.NewInt(llvmTypes.I32, 2), cStringPtr(msg))
+	entry.NewCall(exit, constant.NewInt(llvmTypes.I32, RuntimeErrorExitCode))
+	entry.NewUnreachable()
+	return c.divZero
+}
+
+// 比较运算和逻辑运算作为整数使用, 真为1, 假为0
+func (c *Compiler) processBool(expr ast.Expr) (value.Value, bool) {
+	x, b := c.processCond(expr)
+	if !b {
+		return nil, false
+	}
+	return c.block.NewZExt(x, llvmTypes.I64), true
+}
+
+// 计算只由整数常量组成的表达式, 第二个返回值表示expr是否为常量表达式
+func constValue(expr ast.Expr) (int64, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(e.Value, 0, 64)
+		return v, err == nil
+	case *ast.ParenExpr:
+		return constValue(e.X)
+	case *ast.UnaryExpr:
+		x, ok := constValue(e.X)
+		switch {
+		case !ok:
+			return 0, false
+		case e.Op == token.ADD:
+			return x, true
+		case e.Op == token.SUB:
+			return -x, true
+		case e.Op == token.XOR:
+			return ^x, true
+		}
+	case *ast.BinaryExpr:
+		x, ok1 := constValue(e.X)
+		y, ok2 := constValue(e.Y)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, true
+		case token.SUB:
+			return x - y, true
+		case token.MUL:
+			return x * y, true
+		case token.QUO:
+			if y != 0 {
+				return x / y, true
+			}
+		case token.REM:
+			if y != 0 {
+				return x % y, true
+			}
+		case token.AND:
+			return x & y, true
+		case token.OR:
+			return x | y, true
+		case token.XOR:
+			return x ^ y, true
+		case token.AND_NOT:
+			return x &^ y, true
+		case token.SHL, token.SHR:
+			// 负的移位次数在编译时报错, 不作为常量
+			if y >= 0 {
+				return shiftValue(e.Op, x, y), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// 移位运算的结果, Go对无符号的移位次数的处理和shift生成的指令一致
+func shiftValue(op token.Token, x, y int64) int64 {
+	if op == token.SHL {
+		return x << uint64(y)
+	}
+	return x >> uint64(y)
+}
@@ -0,0 +1,265 @@
+package compiler
+
+import (
+	"bufio"
+	"go/ast"
+	"go/token"
+	"io"
+	"strconv"
+)
+
+// 解释器直接执行通过检查的语法树, 行为和编译出的程序一致:
+//
+//	整数运算按64位补码回绕, 移位次数不小于64时左移得到0, 右移得到0或-1
+//	变量在首次赋值之前的值为0, 函数末尾没有return语句时返回0
+//	除数为0时停止执行, 报告和编译出的程序相同的运行时错误
+type interp struct {
+	out   *bufio.Writer
+	funcs map[string]*interpFunc
+	stmts []ast.Stmt // main函数的语句
+}
+
+// 用户定义的函数
+type interpFunc struct {
+	decl   *ast.FuncDecl
+	params []*ast.Ident
+}
+
+// 一次函数调用中的变量
+type frame map[string]int64
+
+func newInterp(c *Compiler, w io.Writer) *interp {
+	in := &interp{
+		out:   bufio.NewWriter(w),
+		funcs: map[string]*interpFunc{},
+		stmts: c.stmts,
+	}
+	for i, fd := range c.decls {
+		in.funcs[fd.Name.Name] = &interpFunc{decl: fd, params: c.params[i]}
+	}
+	return in
+}
+
+// 执行main函数的语句, 和printf一样输出缓存到结束或者出错时才写入
+func (in *interp) run() error {
+	_, _, err := in.execStmts(frame{}, in.stmts)
+	if e := in.out.Flush(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// 依次执行语句, 遇到return语句时返回true和返回值
+func (in *interp) execStmts(fr frame, stmts []ast.Stmt) (bool, int64, error) {
+	for _, stmt := range stmts {
+		if ret, v, err := in.exec(fr, stmt); ret || err != nil {
+			return ret, v, err
+		}
+	}
+	return false, 0, nil
+}
+
+func (in *interp) exec(fr frame, stmt ast.Stmt) (bool, int64, error) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		v, err := in.eval(fr, s.Rhs[0])
+		if err != nil {
+			return false, 0, err
+		}
+		fr[s.Lhs[0].(*ast.Ident).Name] = v
+
+	case *ast.IfStmt:
+		cond, err := in.eval(fr, s.Cond)
+		if err != nil {
+			return false, 0, err
+		}
+		if cond != 0 {
+			return in.exec(fr, s.Body)
+		}
+		if s.Else != nil {
+			return in.exec(fr, s.Else)
+		}
+
+	case *ast.ForStmt:
+		if s.Init != nil {
+			if ret, v, err := in.exec(fr, s.Init); ret || err != nil {
+				return ret, v, err
+			}
+		}
+		for {
+			if s.Cond != nil {
+				cond, err := in.eval(fr, s.Cond)
+				if err != nil {
+					return false, 0, err
+				}
+				if cond == 0 {
+					break
+				}
+			}
+			if ret, v, err := in.exec(fr, s.Body); ret || err != nil {
+				return ret, v, err
+			}
+			if s.Post != nil {
+				if ret, v, err := in.exec(fr, s.Post); ret || err != nil {
+					return ret, v, err
+				}
+			}
+		}
+
+	case *ast.BlockStmt:
+		return in.execStmts(fr, s.List)
+
+	case *ast.ReturnStmt:
+		if len(s.Results) == 0 {
+			return true, 0, nil
+		}
+		v, err := in.eval(fr, s.Results[0])
+		return true, v, err
+
+	case *ast.ExprStmt:
+		call := s.X.(*ast.CallExpr)
+		if call.Fun.(*ast.Ident).Name == "print" {
+			return false, 0, in.print(fr, call)
+		}
+		_, err := in.call(fr, call)
+		return false, 0, err
+	}
+	return false, 0, nil
+}
+
+// print语句, 输出格式和printf的%lld相同
+func (in *interp) print(fr frame, call *ast.CallExpr) error {
+	var buf []byte
+	for i, arg := range call.Args {
+		v, err := in.eval(fr, arg)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			buf = append(buf, ' ')
+		}
+		buf = strconv.AppendInt(buf, v, 10)
+	}
+	buf = append(buf, '\n')
+	_, err := in.out.Write(buf)
+	return err
+}
+
+// 函数调用, 参数从左到右求值, 每次调用使用新的变量
+func (in *interp) call(fr frame, call *ast.CallExpr) (int64, error) {
+	f := in.funcs[call.Fun.(*ast.Ident).Name]
+	callee := frame{}
+	for i, arg := range call.Args {
+		v, err := in.eval(fr, arg)
+		if err != nil {
+			return 0, err
+		}
+		callee[f.params[i].Name] = v
+	}
+	_, v, err := in.execStmts(callee, f.decl.Body.List)
+	return v, err
+}
+
+// 计算表达式的值, 比较运算和逻辑运算的结果为1或者0
+func (in *interp) eval(fr frame, expr ast.Expr) (int64, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		v, _ := constValue(e)
+		return v, nil
+
+	case *ast.Ident:
+		return fr[e.Name], nil
+
+	case *ast.ParenExpr:
+		return in.eval(fr, e.X)
+
+	case *ast.CallExpr:
+		return in.call(fr, e)
+
+	case *ast.UnaryExpr:
+		x, err := in.eval(fr, e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.SUB:
+			return -x, nil
+		case token.XOR:
+			return ^x, nil
+		case token.NOT:
+			return boolValue(x == 0), nil
+		}
+		return x, nil
+
+	case *ast.BinaryExpr:
+		x, err := in.eval(fr, e.X)
+		if err != nil {
+			return 0, err
+		}
+		// &&和||短路求值
+		switch {
+		case e.Op == token.LAND && x == 0:
+			return 0, nil
+		case e.Op == token.LOR && x != 0:
+			return 1, nil
+		}
+		y, err := in.eval(fr, e.Y)
+		if err != nil {
+			return 0, err
+		}
+		return binaryOp(e.Op, x, y)
+	}
+	return 0, nil
+}
+
+// 二元运算, Go的整数运算已经和LLVM的指令一致, 只需要处理除数为0和移位次数
+func binaryOp(op token.Token, x, y int64) (int64, error) {
+	switch op {
+	case token.ADD:
+		return x + y, nil
+	case token.SUB:
+		return x - y, nil
+	case token.MUL:
+		return x * y, nil
+	case token.QUO, token.REM:
+		if y == 0 {
+			return 0, DivideByZero
+		}
+		if op == token.QUO {
+			return x / y, nil
+		}
+		return x % y, nil
+	case token.AND:
+		return x & y, nil
+	case token.OR:
+		return x | y, nil
+	case token.XOR:
+		return x ^ y, nil
+	case token.AND_NOT:
+		return x &^ y, nil
+	case token.SHL, token.SHR:
+		return shiftValue(op, x, y), nil
+	case token.EQL:
+		return boolValue(x == y), nil
+	case token.NEQ:
+		return boolValue(x != y), nil
+	case token.LSS:
+		return boolValue(x < y), nil
+	case token.LEQ:
+		return boolValue(x <= y), nil
+	case token.GTR:
+		return boolValue(x > y), nil
+	case token.GEQ:
+		return boolValue(x >= y), nil
+	case token.LAND, token.LOR:
+		return boolValue(y != 0), nil
+	}
+	return 0, nil
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
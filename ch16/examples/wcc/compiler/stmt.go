@@ -0,0 +1,319 @@
+package compiler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	llvmTypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// 依次处理语句块中的语句, 某条语句有错误时继续处理后面的语句
+func (c *Compiler) processStmts(stmts []ast.Stmt) bool {
+	ok := true
+	for _, stmt := range stmts {
+		if b := c.processStmt(stmt); !b {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// 根据语句的类型分别处理
+// 返回true表示正常生成代码
+// 返回false表示源代码有语法错误
+func (c *Compiler) processStmt(stmt ast.Stmt) bool {
+	if _, b := stmt.(*ast.BlockStmt); !b {
+		c.lineNo = c.fset.Position(stmt.Pos()).Line
+
+		// 当前语句生成的指令都使用语句开始位置的调试信息
+		c.setLoc(c.dbgLocation(stmt.Pos()))
+	}
+
+	switch s := stmt.(type) {
+	case *ast.AssignStmt: // 赋值语句
+		return c.processAssign(s)
+	case *ast.IfStmt: // if语句
+		return c.processIf(s)
+	case *ast.ForStmt: // while语句或者for语句
+		return c.processFor(s)
+	case *ast.BlockStmt:
+		return c.processStmts(s.List)
+	case *ast.ReturnStmt: // return语句
+		return c.processReturn(s)
+	case *ast.ExprStmt: // print语句或者函数调用
+		if call, b := s.X.(*ast.CallExpr); b {
+			if fn, b := call.Fun.(*ast.Ident); b && fn.Name == "print" {
+				return c.processPrint(call)
+			}
+			_, b := c.processCall(call)
+			return b
+		}
+	case *ast.EmptyStmt:
+		return true
+	}
+	c.errorAt(stmt.Pos(), ErrUnsupported, "不支持的语句")
+	return false
+}
+
+// 变量保存在栈上, 由clang的mem2reg优化为SSA值:
+// 函数中被赋值过的名字都是变量, 在入口处用alloca分配并初始化为0,
+// 赋值语句生成store, 引用变量生成load, 因此变量可以重复赋值
+func (c *Compiler) declareVars(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			assign, b := n.(*ast.AssignStmt)
+			if !b || len(assign.Lhs) != 1 {
+				return true
+			}
+			x, b := assign.Lhs[0].(*ast.Ident)
+			if !b {
+				return true
+			}
+			if _, ok := c.vars[x.Name]; ok {
+				return true
+			}
+			c.lineNo = c.fset.Position(x.Pos()).Line
+
+			// 变量的调试信息使用首次赋值的位置
+			c.setLoc(c.dbgLocation(x.Pos()))
+			c.vars[x.Name] = c.alloca(x.Name, constant.NewInt(llvmTypes.I64, 0))
+			c.dbgDeclareVar(c.vars[x.Name], c.dbgVariable(x.Name))
+			return true
+		})
+	}
+}
+
+// 赋值语句, 左侧只能是一个变量, 右侧是一个表达式
+func (c *Compiler) processAssign(assign *ast.AssignStmt) bool {
+	if len(assign.Lhs) != 1 || len(assign.Rhs) != 1 ||
+		(assign.Tok != token.ASSIGN && assign.Tok != token.DEFINE) {
+		c.errorAt(assign.TokPos, ErrAssign, "不支持的赋值语句")
+		return false
+	}
+	x, b1 := assign.Lhs[0].(*ast.Ident)
+	if !b1 { // 错误：赋值语句左侧只能是变量
+		c.errorAt(assign.Lhs[0].Pos(), ErrAssignLHS, "赋值语句左侧只能是变量")
+		return false
+	}
+	// 计算右侧的值并写入变量
+	v, b2 := c.processExpr(assign.Rhs[0])
+	if b2 {
+		c.block.NewStore(v, c.vars[x.Name])
+	}
+	return b2
+}
+
+// if语句, else分支可以是语句块或者另一个if语句
+//
+//	  br i1 cond, label %if.thenN, label %if.elseN
+//	if.thenN:
+//	  ...
+//	  br label %if.endN
+//	if.elseN:
+//	  ...
+//	  br label %if.endN
+//	if.endN:
+func (c *Compiler) processIf(ifStmt *ast.IfStmt) bool {
+	if ifStmt.Init != nil {
+		c.errorAt(ifStmt.Init.Pos(), ErrIfInit, "if语句不支持初始化语句")
+		return false
+	}
+	c.labelNo++
+	thenBlock := ir.NewBlock(fmt.Sprintf("if.then%d", c.labelNo))
+	elseBlock := ir.NewBlock(fmt.Sprintf("if.else%d", c.labelNo))
+	endBlock := ir.NewBlock(fmt.Sprintf("if.end%d", c.labelNo))
+	if ifStmt.Else == nil {
+		elseBlock = endBlock
+	}
+
+	// 条件有错误时仍然检查各个分支中的语句
+	loc := c.dbgLoc
+	cond, ok := c.processCond(ifStmt.Cond)
+	if ok {
+		c.block.NewCondBr(cond, thenBlock, elseBlock)
+	}
+
+	c.setBlock(thenBlock)
+	if b := c.processStmt(ifStmt.Body); !b {
+		ok = false
+	}
+	c.setLoc(loc)
+	c.block.NewBr(endBlock)
+
+	if ifStmt.Else != nil {
+		c.setBlock(elseBlock)
+		if b := c.processStmt(ifStmt.Else); !b {
+			ok = false
+		}
+		c.setLoc(loc)
+		c.block.NewBr(endBlock)
+	}
+
+	c.setBlock(endBlock)
+	return ok
+}
+
+// while语句, 也可以写成Go风格的for语句, 初始化语句和后置语句都是可选的
+//
+//	  br label %while.condN
+//	while.condN:
+//	  br i1 cond, label %while.bodyN, label %while.endN
+//	while.bodyN:
+//	  ...
+//	  br label %while.condN
+//	while.endN:
+func (c *Compiler) processFor(forStmt *ast.ForStmt) bool {
+	c.labelNo++
+	condBlock := ir.NewBlock(fmt.Sprintf("while.cond%d", c.labelNo))
+	bodyBlock := ir.NewBlock(fmt.Sprintf("while.body%d", c.labelNo))
+	endBlock := ir.NewBlock(fmt.Sprintf("while.end%d", c.labelNo))
+
+	ok := true
+	loc := c.dbgLoc
+	if forStmt.Init != nil {
+		if b := c.processStmt(forStmt.Init); !b {
+			ok = false
+		}
+		c.setLoc(loc)
+	}
+	c.block.NewBr(condBlock)
+
+	// 没有条件时是无限循环
+	c.setBlock(condBlock)
+	if forStmt.Cond != nil {
+		cond, b := c.processCond(forStmt.Cond)
+		if b {
+			c.block.NewCondBr(cond, bodyBlock, endBlock)
+		} else {
+			ok = false
+		}
+	} else {
+		c.block.NewBr(bodyBlock)
+	}
+
+	c.setBlock(bodyBlock)
+	if b := c.processStmt(forStmt.Body); !b {
+		ok = false
+	}
+	if forStmt.Post != nil {
+		if b := c.processStmt(forStmt.Post); !b {
+			ok = false
+		}
+	}
+	c.setLoc(loc)
+	c.block.NewBr(condBlock)
+
+	c.setBlock(endBlock)
+	return ok
+}
+
+// return语句, main函数中不能返回值
+func (c *Compiler) processReturn(ret *ast.ReturnStmt) bool {
+	if len(ret.Results) > 1 || (c.curFunc == "" && len(ret.Results) != 0) {
+		c.errorAt(ret.Pos(), ErrReturn, "函数只能返回一个整数, main函数不能返回值")
+		return false
+	}
+	switch {
+	case c.curFunc == "":
+		c.block.NewRet(constant.NewInt(llvmTypes.I32, 0))
+	case len(ret.Results) == 0:
+		c.block.NewRet(constant.NewInt(llvmTypes.I64, 0))
+	default:
+		x, b := c.processExpr(ret.Results[0])
+		if !b {
+			return false
+		}
+		c.block.NewRet(x)
+	}
+
+	// return之后的语句不可达, 放在一个新的基本块中
+	c.labelNo++
+	c.setBlock(ir.NewBlock(fmt.Sprintf("ret.after%d", c.labelNo)))
+	return true
+}
+
+// 函数调用, 检查函数是否定义过以及参数个数, 返回调用的结果
+func (c *Compiler) processCall(call *ast.CallExpr) (value.Value, bool) {
+	fn, b := call.Fun.(*ast.Ident)
+	if !b {
+		c.errorAt(call.Fun.Pos(), ErrCall, "不支持的函数调用")
+		return nil, false
+	}
+	callee, ok := c.funcs[fn.Name]
+	if !ok {
+		if fn.Name == "print" {
+			c.errorAt(fn.Pos(), ErrPrintExpr, "print没有返回值, 不能用在表达式中")
+		} else {
+			c.errorAt(fn.Pos(), ErrUndefinedFunc, "调用未定义的函数%s", fn.Name)
+		}
+		return nil, false
+	}
+	if len(call.Args) != len(callee.Params) {
+		c.errorAt(call.Lparen, ErrArgCount, "函数%s需要%d个参数，实际传入%d个", fn.Name, len(callee.Params), len(call.Args))
+		return nil, false
+	}
+
+	var args []value.Value
+	for _, arg := range call.Args {
+		x, b := c.processExpr(arg)
+		if !b {
+			return nil, false
+		}
+		args = append(args, x)
+	}
+	return c.block.NewCall(callee, args...), true
+}
+
+// print语句可以打印任意个表达式的值, 用空格分隔, 最后换行
+// 返回true表示正常生成代码
+// 返回false表示源代码有语法错误
+func (c *Compiler) processPrint(call *ast.CallExpr) bool {
+	args := []value.Value{c.printFormat(len(call.Args))}
+	for _, arg := range call.Args {
+		x, b := c.processExpr(arg)
+		if !b {
+			return false
+		}
+		args = append(args, x)
+	}
+
+	// 生成printf, 格式字符串和参数个数一致
+	c.block.NewCall(c.printf, args...)
+	return true
+}
+
+// 打印n个整数的printf格式字符串, 返回字符数组的首地址
+func (c *Compiler) printFormat(n int) constant.Constant {
+	g, ok := c.formats[n]
+	if !ok {
+		format := strings.TrimPrefix(strings.Repeat(" %lld", n), " ") + "\n"
+		g = c.cString(fmt.Sprintf("fmt.%d", n), format)
+		c.formats[n] = g
+	}
+	return cStringPtr(g)
+}
+
+// 以'\0'结尾的字符串常量
+func (c *Compiler) cString(name, s string) *ir.Global {
+	g := c.m.NewGlobalDef(name, constant.NewCharArrayFromString(s+"\x00"))
+	g.Linkage = enum.LinkagePrivate
+	g.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+	g.Immutable = true
+	return g
+}
+
+// 全局字符数组的首地址
+func cStringPtr(g *ir.Global) constant.Constant {
+	ptr := constant.NewGetElementPtr(g.ContentType, g,
+		constant.NewInt(llvmTypes.I64, 0),
+		constant.NewInt(llvmTypes.I64, 0),
+	)
+	ptr.InBounds = true
+	return ptr
+}
@@ -0,0 +1,16 @@
+module wcc
+
+go 1.25.0
+
+require (
+	github.com/llir/llvm v0.3.2
+	llvmdriver v0.0.0
+)
+
+require (
+	github.com/llir/ll v0.0.0-20200425014433-60cd8feecf92 // indirect
+	github.com/mewmew/float v0.0.0-20191226120903-16bbe2fdd85e // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+)
+
+replace llvmdriver => ../../../ch14/examples/llvmdriver